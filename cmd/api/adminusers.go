@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/Wasee3/greenlight-gin/internal/identity"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// UserFilters selects a page of users, search matches username, email and names.
+type UserFilters struct {
+	Search   string `form:"search" binding:"omitempty"`
+	Page     int    `form:"page" binding:"numeric,gte=1"`
+	PageSize int    `form:"pagesize" binding:"numeric,gte=1,lte=100"`
+}
+
+type UserStatusRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+type RequiredActionsRequest struct {
+	Actions []string `json:"actions" binding:"required,min=1,dive,oneof=UPDATE_PASSWORD VERIFY_EMAIL UPDATE_PROFILE CONFIGURE_TOTP TERMS_AND_CONDITIONS"`
+}
+
+// adminUser is a user as shown to admins, with their client roles.
+type adminUser struct {
+	identity.User
+	Roles []string `json:"roles"`
+}
+
+type userListResponse struct {
+	Users    []identity.User `json:"users"`
+	Metadata data.Metadata   `json:"metadata"`
+}
+
+// adminRoles are the client roles admins can grant through the API.
+var adminRoles = []string{"reader", "writer", "admin"}
+
+// tenantUser loads a user the caller may manage, any user for a platform
+// admin and the users of their own tenant for a tenant admin. Users of other
+// tenants are reported as not found.
+func (app *application) tenantUser(ctx context.Context, c *gin.Context, id string) (*identity.User, bool) {
+	start := time.Now()
+	user, err := app.identity.GetUser(ctx, id)
+	DbQueryDuration.WithLabelValues("get_user").Observe(time.Since(start).Seconds())
+	if err == nil {
+		if scope := adminTenant(c); scope != "" && user.Tenant != scope {
+			err = identity.ErrUserNotFound
+		}
+	}
+	if err != nil {
+		app.profileError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func (app *application) ListUsersHandler(c *gin.Context) {
+	filters := UserFilters{Page: 1, PageSize: 20}
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	users, total, err := app.identity.ListUsers(ctx, identity.UserQuery{
+		Search: filters.Search,
+		Tenant: adminTenant(c),
+		First:  (filters.Page - 1) * filters.PageSize,
+		Max:    filters.PageSize,
+	})
+	DbQueryDuration.WithLabelValues("list_users").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	app.auditLogFields(c, "USERS_LISTED", "Admin listed users", logrus.Fields{"search": filters.Search})
+	c.JSON(http.StatusOK, userListResponse{
+		Users: users,
+		Metadata: data.Metadata{
+			CurrentPage:  filters.Page,
+			PageSize:     filters.PageSize,
+			FirstPage:    1,
+			LastPage:     int(math.Ceil(float64(total) / float64(filters.PageSize))),
+			TotalRecords: int64(total),
+		},
+	})
+}
+
+func (app *application) ShowUserHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	user, ok := app.tenantUser(ctx, c, id)
+	if !ok {
+		return
+	}
+	roles, err := app.identity.UserRoles(ctx, id)
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	app.auditLogFields(c, "USER_VIEWED", "Admin viewed a user", logrus.Fields{"target_user": id})
+	c.JSON(http.StatusOK, gin.H{"user": adminUser{User: *user, Roles: roles}})
+}
+
+func (app *application) UpdateUserStatusHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+	id := c.Param("id")
+
+	var req UserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Disabling yourself would lock the last admin out just as easily as anyone
+	if !*req.Enabled && id == principalFrom(c).Subject {
+		c.JSON(http.StatusConflict, gin.H{"error": "You cannot disable your own account"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	if _, ok := app.tenantUser(ctx, c, id); !ok {
+		return
+	}
+
+	start := time.Now()
+	err := app.identity.SetEnabled(ctx, id, *req.Enabled)
+	DbQueryDuration.WithLabelValues("update_user").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	action, message := "USER_ENABLED", "User enabled"
+	if !*req.Enabled {
+		action, message = "USER_DISABLED", "User disabled"
+		// A disabled account must not keep the sessions it already has
+		if err := app.identity.LogoutAll(ctx, id); err != nil && !errors.Is(err, identity.ErrNotSupported) {
+			app.logger.Error("Failed to end the sessions of a disabled user", "error", err)
+		}
+	}
+	app.auditLogFields(c, action, message, logrus.Fields{"target_user": id})
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (app *application) AssignUserRoleHandler(c *gin.Context) {
+	app.changeUserRole(c, true)
+}
+
+func (app *application) RemoveUserRoleHandler(c *gin.Context) {
+	app.changeUserRole(c, false)
+}
+
+func (app *application) changeUserRole(c *gin.Context, assign bool) {
+	id, role := c.Param("id"), c.Param("role")
+	if !slices.Contains(adminRoles, role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "allowed_roles": adminRoles})
+		return
+	}
+	if !assign && role == "admin" && id == principalFrom(c).Subject {
+		c.JSON(http.StatusConflict, gin.H{"error": "You cannot remove your own admin role"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	if _, ok := app.tenantUser(ctx, c, id); !ok {
+		return
+	}
+
+	change, action, message := app.identity.AddRole, "ROLE_ASSIGNED", "Role "+role+" assigned"
+	if !assign {
+		change, action, message = app.identity.RemoveRole, "ROLE_REMOVED", "Role "+role+" removed"
+	}
+
+	start := time.Now()
+	err := change(ctx, id, role)
+	DbQueryDuration.WithLabelValues("update_user_roles").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	app.auditLogFields(c, action, message, logrus.Fields{"target_user": id, "role": role})
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (app *application) EndUserSessionsHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	if _, ok := app.tenantUser(ctx, c, id); !ok {
+		return
+	}
+
+	start := time.Now()
+	err := app.identity.LogoutAll(ctx, id)
+	DbQueryDuration.WithLabelValues("logout_user").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	app.auditLogFields(c, "USER_LOGGED_OUT", "All sessions of the user ended", logrus.Fields{"target_user": id})
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions ended, access tokens stay valid until they expire"})
+}
+
+// UnlockUserHandler lifts a login lockout of the user and forgets their failed logins.
+func (app *application) UnlockUserHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	user, ok := app.tenantUser(ctx, c, id)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	cleared, err := app.models.Logins.Reset(ctx, loginUserKey(user.Username))
+	DbQueryDuration.WithLabelValues("login_attempts").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("login_attempts").Inc()
+		app.logger.Error("Failed to reset login attempts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	app.auditLogFields(c, "USER_UNLOCKED", "Login lockout of the user lifted", logrus.Fields{"target_user": id, "had_failures": cleared > 0})
+	c.JSON(http.StatusOK, gin.H{"message": "Login failures cleared"})
+}
+
+func (app *application) RequireUserActionsHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+	id := c.Param("id")
+
+	var req RequiredActionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	if _, ok := app.tenantUser(ctx, c, id); !ok {
+		return
+	}
+
+	start := time.Now()
+	err := app.identity.RequireActions(ctx, id, req.Actions)
+	DbQueryDuration.WithLabelValues("require_actions").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	app.auditLogFields(c, "USER_ACTIONS_REQUIRED", "Required actions sent to the user", logrus.Fields{"target_user": id, "actions": req.Actions})
+	c.JSON(http.StatusOK, gin.H{"message": "Required actions emailed to the user"})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxKeyOverlap bounds how long a rotated key keeps working next to its successor.
+const maxKeyOverlap = 30 * 24 * time.Hour
+
+type APIKeyRotateRequest struct {
+	// Overlap is a Go duration such as "24h", the old key is valid for that long
+	Overlap string `json:"overlap" binding:"omitempty"`
+}
+
+type apiKeyCreatedResponse struct {
+	Message string      `json:"message"`
+	Key     string      `json:"key"`
+	APIKey  data.APIKey `json:"api_key"`
+}
+
+// authenticateAPIKey resolves an X-API-Key header to the key's principal. It
+// aborts the request and returns nil if the key is unknown, revoked or expired.
+func (app *application) authenticateAPIKey(c *gin.Context, raw string) *principal {
+	reject := func(reason string) *principal {
+		app.auditLog(c, "UNAUTHORIZED", reason)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return nil
+	}
+
+	prefix, ok := data.ParseAPIKey(raw)
+	if !ok {
+		return reject("Malformed API key")
+	}
+
+	start := time.Now()
+	key, err := app.models.APIKeys.GetByPrefix(c.Request.Context(), prefix)
+	DbQueryDuration.WithLabelValues("get_api_key").Observe(time.Since(start).Seconds())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return reject("Unknown API key " + prefix)
+		}
+		DbQueryErrorsTotal.WithLabelValues("get_api_key").Inc()
+		app.logger.Error("Failed to look up API key", "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return nil
+	}
+	if !key.Matches(raw) {
+		return reject("API key " + prefix + " does not match")
+	}
+	if !key.Active(time.Now()) {
+		return reject("API key " + prefix + " is revoked or expired")
+	}
+
+	if err := app.models.APIKeys.Touch(c.Request.Context(), key.ID); err != nil {
+		DbQueryErrorsTotal.WithLabelValues("touch_api_key").Inc()
+		app.logger.Error("Failed to record API key use", "prefix", prefix, "error", err)
+	}
+
+	return &principal{
+		Subject:  "apikey:" + key.Prefix,
+		Username: key.Name,
+		Tenant:   key.TenantID,
+		Roles:    key.Roles,
+		Scopes:   key.Scopes,
+		Claims:   map[string]any{"sub": "apikey:" + key.Prefix, "api_key": key.Prefix},
+	}
+}
+
+func (app *application) CreateAPIKeyHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+
+	var input data.APIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(input.Roles) == 0 && len(input.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An API key needs at least one role or scope"})
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	// Keys of other tenants are for platform admins, tenant admins mint keys
+	// for their own tenant only
+	if scope := adminTenant(c); input.Tenant == "" {
+		input.Tenant = c.GetString(data.TenantContextKey)
+	} else if scope != "" && input.Tenant != scope {
+		app.auditLog(c, "FORBIDDEN", fmt.Sprintf("Tenant admin of %s tried to create an API key for tenant %s", scope, input.Tenant))
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only create API keys for your own tenant"})
+		return
+	}
+	// A key must not grant more than its creator holds
+	creator := principalFrom(c)
+	for _, role := range input.Roles {
+		if !slices.Contains(creator.Roles, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You cannot grant the role %s, you do not hold it", role)})
+			return
+		}
+	}
+	for _, scope := range input.Scopes {
+		if !app.policy.GrantsScope(creator, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You cannot grant the scope %s, you do not hold it", scope)})
+			return
+		}
+	}
+	if _, err := app.tenantSettings(c.Request.Context(), input.Tenant); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tenant %s not found", input.Tenant)})
+		} else {
+			app.logger.Error("Failed to load tenant", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	secret, prefix, hash, err := data.NewAPIKeySecret()
+	if err != nil {
+		app.logger.Error("Failed to generate API key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	key := &data.APIKey{
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Name:      input.Name,
+		TenantID:  input.Tenant,
+		Roles:     input.Roles,
+		Scopes:    input.Scopes,
+		CreatedBy: c.GetString(data.SubjectContextKey),
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	start := time.Now()
+	err = app.models.APIKeys.Insert(c, key)
+	DbQueryDuration.WithLabelValues("create_api_key").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("create_api_key").Inc()
+		app.logger.Error("Failed to create API key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	app.auditLog(c, "API_KEY_CREATED", fmt.Sprintf("API key %s (%s) created for tenant %s", key.Prefix, key.Name, key.TenantID))
+	// The key is only ever shown in this response
+	c.JSON(http.StatusCreated, gin.H{"message": "API key created", "key": secret, "api_key": key})
+}
+
+func (app *application) ListAPIKeysHandler(c *gin.Context) {
+	// Tenant admins only ever see their own tenant's keys
+	tenant := adminTenant(c)
+	if tenant == "" {
+		tenant = c.Query("tenant")
+	}
+
+	start := time.Now()
+	keys, err := app.models.APIKeys.List(c, tenant)
+	DbQueryDuration.WithLabelValues("list_api_keys").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("list_api_keys").Inc()
+		app.logger.Error("Failed to list API keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (app *application) RevokeAPIKeyHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id parameter"})
+		return
+	}
+
+	start := time.Now()
+	key, err := app.models.APIKeys.Revoke(c, id, adminTenant(c))
+	DbQueryDuration.WithLabelValues("revoke_api_key").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("revoke_api_key").Inc()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("API key %d not found", id)})
+		} else {
+			app.logger.Error("Failed to revoke API key", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	app.auditLog(c, "API_KEY_REVOKED", fmt.Sprintf("API key %s (%s) revoked", key.Prefix, key.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "api_key": key})
+}
+
+func (app *application) RotateAPIKeyHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id parameter"})
+		return
+	}
+
+	var req APIKeyRotateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	overlap := 24 * time.Hour
+	if req.Overlap != "" {
+		overlap, err = time.ParseDuration(req.Overlap)
+		if err != nil || overlap < 0 || overlap > maxKeyOverlap {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "overlap must be a duration between 0s and 720h"})
+			return
+		}
+	}
+
+	secret, prefix, hash, err := data.NewAPIKeySecret()
+	if err != nil {
+		app.logger.Error("Failed to generate API key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	replacement := &data.APIKey{Prefix: prefix, KeyHash: hash, CreatedBy: c.GetString(data.SubjectContextKey)}
+
+	start := time.Now()
+	old, err := app.models.APIKeys.Rotate(c, id, adminTenant(c), replacement, overlap)
+	DbQueryDuration.WithLabelValues("rotate_api_key").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("rotate_api_key").Inc()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("API key %d not found", id)})
+		case errors.Is(err, data.ErrKeyInactive):
+			c.JSON(http.StatusConflict, gin.H{"error": "Revoked or expired keys cannot be rotated"})
+		default:
+			app.logger.Error("Failed to rotate API key", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	app.auditLog(c, "API_KEY_ROTATED", fmt.Sprintf("API key %s rotated to %s, old key valid until %s", old.Prefix, replacement.Prefix, old.ExpiresAt.Format(time.RFC3339)))
+	c.JSON(http.StatusCreated, gin.H{"message": "API key rotated", "key": secret, "api_key": replacement, "previous": old})
+}
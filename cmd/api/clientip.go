@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPSources are the values of -client-ip-header. With none the address
+// of the connection is the client's.
+var clientIPSources = []string{"none", "x-forwarded-for", "x-real-ip", "forwarded", "proxy-protocol"}
+
+// clientIPConfig says where the client address of a request comes from. A
+// header is only believed when the request arrives from a trusted proxy.
+type clientIPConfig struct {
+	header         string
+	trustedProxies []netip.Prefix
+}
+
+// parseTrustedProxies reads CIDRs and single addresses, separated by commas or spaces.
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.FieldsFunc(val, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if addr, err := netip.ParseAddr(field); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, want an address or CIDR", field)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (cfg clientIPConfig) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return slices.ContainsFunc(cfg.trustedProxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// resolve returns the client address of a request that came from peer.
+// Proxies append to X-Forwarded-For and Forwarded, so the list is read from
+// the right and the first hop that is not a trusted proxy is the client;
+// everything left of it may have been made up by the client.
+func (cfg clientIPConfig) resolve(peer netip.Addr, h http.Header) netip.Addr {
+	if !cfg.trusted(peer) {
+		return peer
+	}
+
+	var hops []string
+	switch cfg.header {
+	case "x-real-ip":
+		if addr, ok := parseHop(h.Get("X-Real-IP")); ok {
+			return addr
+		}
+		return peer
+	case "x-forwarded-for":
+		for _, value := range h.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	case "forwarded":
+		hops = forwardedFor(h.Values("Forwarded"))
+	default:
+		return peer
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// An obfuscated or garbled hop, the last proxy is all we know
+			break
+		}
+		client = addr
+		if !cfg.trusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor collects the for= parameters of RFC 7239 Forwarded headers,
+// one per element, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				name, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(name, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop reads an address with or without a port, IPv6 possibly in brackets.
+func parseHop(raw string) (netip.Addr, bool) {
+	raw = strings.TrimSpace(raw)
+	if addr, err := netip.ParseAddr(raw); err == nil {
+		return addr.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(raw); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// viaTrustedProxyKey is set on requests that came from a trusted proxy, only
+// their X-Forwarded-* headers are believed.
+const viaTrustedProxyKey = "via_trusted_proxy"
+
+// ClientIPMiddleware replaces the remote address of a request from a trusted
+// proxy with the client's. It runs first, and gin trusts no proxy on its own,
+// so c.ClientIP() gives the same answer to the rate limits, login lockout,
+// proof of work, audit log, traces and request log.
+func (app *application) ClientIPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, port, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			c.Next()
+			return
+		}
+		peer, err := netip.ParseAddr(host)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		if app.config.clientIP.trusted(peer) {
+			c.Set(viaTrustedProxyKey, true)
+		}
+		if client := app.config.clientIP.resolve(peer.Unmap(), c.Request.Header); client != peer.Unmap() {
+			c.Request.RemoteAddr = net.JoinHostPort(client.String(), port)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Wasee3/greenlight-gin/internal/identity"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultDevUsers are the accounts of the development identity provider when
+// no -dev-users file is given.
+//
+//go:embed devusers.yaml
+var defaultDevUsers []byte
+
+func loadDevUsers(path string) ([]identity.DevUser, error) {
+	raw := defaultDevUsers
+	if path != "" {
+		var err error
+		raw, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read dev users: %w", err)
+		}
+	}
+	var file struct {
+		Users []identity.DevUser `yaml:"users"`
+	}
+	if err := yaml.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("parse dev users: %w", err)
+	}
+	return file.Users, nil
+}
+
+func (app *application) DevDiscoveryHandler(c *gin.Context) {
+	discovery := app.dev.Discovery()
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                discovery.Issuer,
+		"token_endpoint":                        discovery.TokenEndpoint,
+		"jwks_uri":                              discovery.JWKSURI,
+		"revocation_endpoint":                   discovery.RevocationEndpoint,
+		"grant_types_supported":                 []string{"password", "refresh_token"},
+		"response_types_supported":              []string{"token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+func (app *application) DevJWKSHandler(c *gin.Context) {
+	set, err := app.dev.KeySet()
+	if err != nil {
+		app.logger.Error("Failed to build the dev JWKS", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, set)
+}
+
+// DevTokenHandler implements the password and refresh_token grants of the
+// token endpoint, answering errors as in RFC 6749 section 5.2.
+func (app *application) DevTokenHandler(c *gin.Context) {
+	var (
+		token *identity.TokenSet
+		err   error
+	)
+	switch c.PostForm("grant_type") {
+	case "password":
+		token, err = app.dev.Login(c.Request.Context(), c.PostForm("username"), c.PostForm("password"))
+	case "refresh_token":
+		token, err = app.dev.Refresh(c.Request.Context(), c.PostForm("refresh_token"))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+	if errors.Is(err, identity.ErrInvalidCredentials) || errors.Is(err, identity.ErrInvalidToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": err.Error()})
+		return
+	}
+	if err != nil {
+		app.logger.Error("Failed to issue dev token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, token)
+}
+
+// DevRevokeHandler revokes refresh tokens as in RFC 7009, unknown tokens are
+// not an error there.
+func (app *application) DevRevokeHandler(c *gin.Context) {
+	_ = app.dev.Logout(c.Request.Context(), c.PostForm("token"))
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/gin-gonic/gin"
+)
+
+type FeedQuery struct {
+	Genre  []string `form:"genre" binding:"omitempty,dive,min=1"`
+	Limit  int      `form:"limit" binding:"omitempty,gte=1,lte=100"`
+	Tenant string   `form:"tenant" binding:"omitempty"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (app *application) MoviesRSSHandler(c *gin.Context) {
+	movies, ok := app.feedMovies(c)
+	if !ok {
+		return
+	}
+
+	base := app.feedBaseURL(c)
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feedTitle(c),
+			Link:        base + "/v1/movie",
+			Description: "Movies most recently added to the Greenlight catalog",
+		},
+	}
+	if len(movies) > 0 {
+		feed.Channel.LastBuildDate = movies[0].CreatedAt.UTC().Format(time.RFC1123Z)
+	}
+	for _, movie := range movies {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       fmt.Sprintf("%s (%d)", movie.Title, movie.Year),
+			Link:        fmt.Sprintf("%s/v1/movie/%d", base, movie.ID),
+			GUID:        rssGUID{Value: movieTag(movie)},
+			PubDate:     movie.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: movieSummary(movie),
+			Categories:  movie.Genres,
+		})
+	}
+
+	app.writeFeed(c, "application/rss+xml; charset=utf-8", feed)
+}
+
+func (app *application) MoviesAtomHandler(c *gin.Context) {
+	movies, ok := app.feedMovies(c)
+	if !ok {
+		return
+	}
+
+	base := app.feedBaseURL(c)
+	feed := atomFeed{
+		Title:   feedTitle(c),
+		ID:      "tag:greenlight,2025:feeds:movies" + genreSuffix(c),
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: base + c.Request.URL.RequestURI()},
+			{Rel: "alternate", Href: base + "/v1/movie"},
+		},
+	}
+	if len(movies) > 0 {
+		feed.Updated = movies[0].CreatedAt.UTC().Format(time.RFC3339)
+	}
+	for _, movie := range movies {
+		entry := atomEntry{
+			Title:     fmt.Sprintf("%s (%d)", movie.Title, movie.Year),
+			ID:        movieTag(movie),
+			Updated:   movie.CreatedAt.UTC().Format(time.RFC3339),
+			Published: movie.CreatedAt.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: fmt.Sprintf("%s/v1/movie/%d", base, movie.ID)},
+			Summary:   movieSummary(movie),
+		}
+		for _, genre := range movie.Genres {
+			entry.Categories = append(entry.Categories, atomCategory{Term: genre})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	app.writeFeed(c, "application/atom+xml; charset=utf-8", feed)
+}
+
+// feedMovies loads the movies for a feed and answers conditional requests. It
+// returns false when the response has already been written.
+func (app *application) feedMovies(c *gin.Context) ([]data.Movies, bool) {
+	query := FeedQuery{Limit: 20}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	var genres []string
+	for _, g := range query.Genre {
+		for _, genre := range strings.Split(g, ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				genres = append(genres, genre)
+			}
+		}
+	}
+
+	start := time.Now()
+	movies, err := app.models.Movies.Recent(c, genres, query.Limit)
+	DbQueryDuration.WithLabelValues("feed_movies").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("feed_movies").Inc()
+		app.logger.Error("Failed to load feed movies", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+
+	// The ETag covers the tenant and every movie in the feed so that edits to
+	// a listed movie are picked up as well as new additions.
+	hash := sha256.New()
+	hash.Write([]byte(c.GetString(data.TenantContextKey) + "|" + c.Request.URL.RawQuery))
+	var lastModified time.Time
+	for _, movie := range movies {
+		fmt.Fprintf(hash, "|%d:%d:%d", movie.ID, movie.Version, movie.UpdatedAt.Unix())
+		if movie.UpdatedAt.After(lastModified) {
+			lastModified = movie.UpdatedAt
+		}
+	}
+	etag := `W/"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+
+	c.Header("ETag", etag)
+	if app.config.feeds.requireAuth {
+		// The tenant comes from the credentials, the same URL serves every tenant
+		c.Header("Cache-Control", "private, max-age=60")
+		c.Header("Vary", "Authorization, X-API-Key")
+	} else {
+		c.Header("Cache-Control", "public, max-age=60")
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		if etagMatches(match, etag) {
+			c.Status(http.StatusNotModified)
+			return nil, false
+		}
+	} else if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			c.Status(http.StatusNotModified)
+			return nil, false
+		}
+	}
+
+	return movies, true
+}
+
+func (app *application) writeFeed(c *gin.Context, contentType string, feed any) {
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		app.logger.Error("Failed to encode feed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), out...))
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// feedBaseURL is the -public-base-url the feed links start with. Without one
+// it falls back to the Host header, which shared caches key on, and believes
+// X-Forwarded-Proto only from a trusted proxy, so a client cannot plant links
+// in a cached feed.
+func (app *application) feedBaseURL(c *gin.Context) string {
+	if app.config.feeds.baseURL != "" {
+		return app.config.feeds.baseURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || (c.GetBool(viaTrustedProxyKey) && c.GetHeader("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func feedTitle(c *gin.Context) string {
+	title := "Greenlight: recently added movies"
+	if genres := c.QueryArray("genre"); len(genres) > 0 {
+		title += " in " + strings.Join(genres, ", ")
+	}
+	return title
+}
+
+func genreSuffix(c *gin.Context) string {
+	if genres := c.QueryArray("genre"); len(genres) > 0 {
+		return ":" + strings.Join(genres, ",")
+	}
+	return ""
+}
+
+func movieTag(movie data.Movies) string {
+	return "tag:greenlight,2025:" + movie.TenantID + ":movie:" + strconv.FormatInt(movie.ID, 10)
+}
+
+func movieSummary(movie data.Movies) string {
+	return fmt.Sprintf("%s, %d minutes, released %d", strings.Join(movie.Genres, ", "), movie.Runtime, movie.Year)
+}
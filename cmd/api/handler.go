@@ -7,14 +7,17 @@ import (
 	"math"
 	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/Nerzal/gocloak/v13"
 	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/Wasee3/greenlight-gin/internal/identity"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jinzhu/copier"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -32,9 +35,20 @@ func (app *application) ShowMovieHandler(c *gin.Context) {
 		return
 	}
 
+	var projection data.Projection
+	if err := c.ShouldBindQuery(&projection); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, includes, err := projection.Parse()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var movie *data.Movies
 	start := time.Now()
-	movie, err = app.models.Movies.Get(c, id)
+	movie, err = app.models.Movies.Get(c, id, fields)
 
 	if err != nil {
 		duration := time.Since(start).Seconds()
@@ -58,7 +72,14 @@ func (app *application) ShowMovieHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error by Copier"})
 		return
 	}
-	c.JSON(http.StatusOK, input)
+
+	columns, records, err := app.projectMovies(c, []data.Input{input}, fields, includes)
+	if err != nil {
+		app.logger.Error("Failed to load includes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	app.respond(c, http.StatusOK, envelope{Single: true, Columns: columns, Records: records})
 
 }
 
@@ -68,11 +89,15 @@ func (app *application) CreateMovieHandler(c *gin.Context) {
 
 	var input data.Input
 
-	if err := c.ShouldBindJSON(&input); err != nil {
+	if err := app.bindBody(c, &input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !app.checkTenantGenres(c, input.Genres) {
+		return
+	}
+
 	movie := &data.Movies{
 		ID:        input.ID,
 		CreatedAt: time.Now(),
@@ -96,9 +121,15 @@ func (app *application) CreateMovieHandler(c *gin.Context) {
 	duration := time.Since(start).Seconds()
 	DbQueryDuration.WithLabelValues("create_movie").Observe(duration)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Data received successfully",
-		"data":    input,
+	// Pick up the owner columns filled in by the insert
+	_ = copier.Copy(&input, movie)
+	columns, records, _ := app.projectMovies(c, []data.Input{input}, nil, nil)
+	app.respond(c, http.StatusOK, envelope{
+		Message: "Data received successfully",
+		Key:     "data",
+		Single:  true,
+		Columns: columns,
+		Records: records,
 	})
 }
 
@@ -121,20 +152,27 @@ func (app *application) UpdateMovieHandler(c *gin.Context) {
 
 	// Bind JSON request body to `update` struct
 	var update data.Update
-	if err := c.ShouldBindJSON(&update); err != nil {
+	if err := app.bindBody(c, &update); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !app.checkTenantGenres(c, update.Genres) {
+		return
+	}
+
 	// Update the movie inside a transaction
 	start := time.Now()
-	updatedMovie, err := app.models.Movies.UpdateMovieInTransaction(c, id, update)
+	updatedMovie, err := app.models.Movies.UpdateMovieInTransaction(c, id, update, app.ownerFilter(c))
 	if err != nil {
 		duration := time.Since(start).Seconds()
 		DbQueryDuration.WithLabelValues("update_movie").Observe(duration)
 		DbQueryErrorsTotal.WithLabelValues("update_movie").Inc()
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Movie with ID %d not found", id)})
+		} else if errors.Is(err, data.ErrNotOwner) {
+			app.auditLog(c, "FORBIDDEN", fmt.Sprintf("Update of movie %d by non-owner", id))
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner or an admin can update this movie"})
 		} else if strings.HasPrefix(err.Error(), "concurrent_update:") {
 			c.JSON(http.StatusConflict, gin.H{"error": "Movie was modified by another request. Please retry."})
 		} else {
@@ -146,8 +184,22 @@ func (app *application) UpdateMovieHandler(c *gin.Context) {
 	duration := time.Since(start).Seconds()
 	DbQueryDuration.WithLabelValues("update_movie").Observe(duration)
 
+	var input data.Input
+	if err := copier.Copy(&input, updatedMovie); err != nil {
+		app.logger.Error("Copier error", "error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error by Copier"})
+		return
+	}
+
 	// Respond with the updated movie data
-	c.JSON(http.StatusOK, gin.H{"message": "Movie updated successfully", "movie": updatedMovie})
+	columns, records, _ := app.projectMovies(c, []data.Input{input}, nil, nil)
+	app.respond(c, http.StatusOK, envelope{
+		Message: "Movie updated successfully",
+		Key:     "movie",
+		Single:  true,
+		Columns: columns,
+		Records: records,
+	})
 }
 
 func (app *application) DeleteMovieHandler(c *gin.Context) {
@@ -165,7 +217,7 @@ func (app *application) DeleteMovieHandler(c *gin.Context) {
 	}
 
 	start := time.Now()
-	err = app.models.Movies.Delete(c, id)
+	err = app.models.Movies.Delete(c, id, app.ownerFilter(c))
 
 	if err != nil {
 		duration := time.Since(start).Seconds()
@@ -173,6 +225,9 @@ func (app *application) DeleteMovieHandler(c *gin.Context) {
 		DbQueryErrorsTotal.WithLabelValues("delete_movie").Inc()
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Movie with ID %d not found", id)})
+		} else if errors.Is(err, data.ErrNotOwner) {
+			app.auditLog(c, "FORBIDDEN", fmt.Sprintf("Delete of movie %d by non-owner", id))
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner or an admin can delete this movie"})
 		} else {
 			app.logger.Error("Database error", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -182,7 +237,7 @@ func (app *application) DeleteMovieHandler(c *gin.Context) {
 	duration := time.Since(start).Seconds()
 	DbQueryDuration.WithLabelValues("delete_movie").Observe(duration)
 
-	c.JSON(http.StatusOK, gin.H{"Message": fmt.Sprintf("Movie with ID %d deleted", id)})
+	app.respond(c, http.StatusOK, envelope{Message: fmt.Sprintf("Movie with ID %d deleted", id)})
 }
 
 func (app *application) ListMovieHandler(c *gin.Context) {
@@ -201,15 +256,20 @@ func (app *application) ListMovieHandler(c *gin.Context) {
 		return
 	}
 
+	fields, includes, err := filter.Projection.Parse()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var movies *[]data.Movies
-	var err error
 	var tr int64
 	var metadata *data.Metadata
 
 	start := time.Now()
 	if filter.Title != "" {
 		filter.Pretty = true
-		movies, tr, err = app.models.Movies.Search(c, filter)
+		movies, tr, err = app.models.Movies.Search(c, filter, fields)
 		metadata = &data.Metadata{
 			CurrentPage:  filter.Page,
 			PageSize:     filter.PageSize,
@@ -218,13 +278,13 @@ func (app *application) ListMovieHandler(c *gin.Context) {
 			TotalRecords: int64(tr),
 		}
 	} else {
-		movies, err = app.models.Movies.List(c, filter)
+		movies, tr, err = app.models.Movies.List(c, filter, fields)
 		metadata = &data.Metadata{
 			CurrentPage:  filter.Page,
 			PageSize:     filter.PageSize,
 			FirstPage:    1,
 			LastPage:     int(math.Ceil(float64(tr) / float64(filter.PageSize))),
-			TotalRecords: int64(totalMoviesCount),
+			TotalRecords: int64(tr),
 		}
 	}
 
@@ -247,11 +307,105 @@ func (app *application) ListMovieHandler(c *gin.Context) {
 		return
 	}
 
-	if filter.Pretty {
-		c.IndentedJSON(http.StatusOK, gin.H{"Metadata": metadata, "movies": input})
-	} else {
-		c.JSON(http.StatusOK, gin.H{"Metadata": metadata, "movies": input})
+	columns, records, err := app.projectMovies(c, input, fields, includes)
+	if err != nil {
+		app.logger.Error("Failed to load includes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	app.respond(c, http.StatusOK, envelope{
+		Metadata: metadata,
+		Key:      "movies",
+		Columns:  columns,
+		Records:  records,
+		Pretty:   filter.Pretty,
+	})
+}
+
+// projectMovies trims each movie down to the requested fields and embeds the
+// requested related data under its include name. The returned columns list
+// the record keys in output order.
+func (app *application) projectMovies(c *gin.Context, input []data.Input, fields, includes []string) ([]string, []gin.H, error) {
+	var loaded map[string]map[int64]any
+	if len(includes) > 0 && len(input) > 0 {
+		ids := make([]int64, len(input))
+		for i, movie := range input {
+			ids[i] = movie.ID
+		}
+
+		start := time.Now()
+		var err error
+		loaded, err = app.models.Movies.LoadIncludes(c, includes, ids)
+		DbQueryDuration.WithLabelValues("load_includes").Observe(time.Since(start).Seconds())
+		if err != nil {
+			DbQueryErrorsTotal.WithLabelValues("load_includes").Inc()
+			return nil, nil, err
+		}
+	}
+
+	out := make([]gin.H, len(input))
+	for i, movie := range input {
+		out[i] = data.Project(movie, fields)
+		for _, name := range includes {
+			out[i][name] = loaded[name][movie.ID]
+		}
+	}
+	return append(data.FieldNames(fields), includes...), out, nil
+}
+
+// ownerFilter returns the subject update and delete are restricted to, or an
+// empty string for admins who may change any movie in their tenant.
+func (app *application) ownerFilter(c *gin.Context) string {
+	if p := principalFrom(c); p != nil && hasRequiredRole(p.Roles, []string{"admin"}) {
+		return ""
 	}
+	return c.GetString(data.SubjectContextKey)
+}
+
+type OwnerTransferRequest struct {
+	Owner string `json:"owner" binding:"required,min=1,max=255"`
+}
+
+func (app *application) TransferMovieOwnerHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id parameter"})
+		return
+	}
+
+	var req OwnerTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start := time.Now()
+	movie, err := app.models.Movies.TransferOwnership(c, id, req.Owner)
+	duration := time.Since(start).Seconds()
+	DbQueryDuration.WithLabelValues("transfer_movie").Observe(duration)
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("transfer_movie").Inc()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Movie with ID %d not found", id)})
+		} else {
+			app.logger.Error("Failed to transfer movie", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	app.auditLog(c, "OWNER_TRANSFERRED", fmt.Sprintf("Movie %d transferred to %s", id, req.Owner))
+
+	var input data.Input
+	if err := copier.Copy(&input, movie); err != nil {
+		app.logger.Error("Copier error", "error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error by Copier"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred", "movie": input})
 }
 
 func (app *application) RegisterUserHandler(c *gin.Context) {
@@ -265,11 +419,12 @@ func (app *application) RegisterUserHandler(c *gin.Context) {
 				"required_fields": gin.H{
 					"Username":   "Allowed chars: a-z, A-Z, 0-9, _ pr - min: 2, max: 20",
 					"Email":      "Valid Email, max: 40",
-					"Password":   "Allowed chars: a-z, A-Z, 0-9, _, -, @ min: 10, max: 20",
+					"Password":   fmt.Sprintf("min: %d, max: %d, at least %d of lower case, upper case, digits and symbols", app.config.password.minLength, app.config.password.maxLength, app.config.password.classes),
 					"First Name": "Allowed chars: a-z, A-Z, - min: 2, max: 20",
 					"Last Name":  "Allowed chars: a-z, A-Z, - min: 2, max: 20",
 				},
-				"error": err.Error(),
+				"schema": "/v1/openapi.json#/components/schemas/User",
+				"error":  err.Error(),
 			})
 		return
 	}
@@ -281,10 +436,8 @@ func (app *application) RegisterUserHandler(c *gin.Context) {
 		return
 	}
 
-	match, err = regexp.MatchString(`^[a-zA-Z0-9_@]+$`, user.Password)
-	if !match {
-		app.logger.Error("Invalid Password", "error", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Password"})
+	if problem := app.config.password.checkPassword(user.Password, user.Username, user.Email); problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
 		return
 	}
 
@@ -302,72 +455,117 @@ func (app *application) RegisterUserHandler(c *gin.Context) {
 		return
 	}
 
-	realm := app.config.kc.Realm
-	admin_username := app.config.kc.admin_username
-	admin_password := app.config.kc.admin_password
-
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
 	defer cancel()
 
-	// token, err := app.client.LoginAdmin(admin_username, admin_password, realm)
-	token, err := app.client.LoginAdmin(ctx, admin_username, admin_password, realm)
-	if err != nil {
-		app.logger.Error("Failed to login to Keycloak", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	_, err = app.identity.FindUser(ctx, user.Username)
+	switch {
+	case err == nil:
+		app.logger.Warn("User Exists, Cannot create", "username", user.Username)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists", "username": user.Username})
 		return
-	}
-
-	users, err := app.client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
-		Username: &user.Username,
-		Max:      gocloak.IntP(1), // Get only 1 user
-	})
-	if err != nil {
-		app.logger.Error("Failed to search user in Keycloak", "error", err)
+	case errors.Is(err, identity.ErrNotSupported):
+		app.identityNotSupported(c)
+		return
+	case !errors.Is(err, identity.ErrUserNotFound):
+		app.logger.Error("Failed to search user in the identity provider", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	if len(users) > 0 {
-		app.logger.Warn("User Exists, Cannot create", "username", user.Username)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists", "username": user.Username})
-		return
+	// The code is redeemed before the user exists so that a used up code
+	// cannot be raced, it is given back if the user cannot be created
+	var invitation *data.Invitation
+	if user.InvitationCode != "" {
+		invitation, err = app.models.Invitations.Redeem(ctx, user.InvitationCode)
+		if err != nil {
+			if errors.Is(err, data.ErrInvitationInvalid) {
+				UserRegistrationsTotal.WithLabelValues("invalid_invitation").Inc()
+				app.auditLog(c, "INVITATION_REJECTED", "Registration of "+user.Username+" with an invalid invitation code")
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation code"})
+				return
+			}
+			DbQueryErrorsTotal.WithLabelValues("redeem_invitation").Inc()
+			app.logger.Error("Failed to redeem invitation", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
 	}
 
-	kcuser := gocloak.User{
-		Username:      gocloak.StringP(user.Username),
-		FirstName:     gocloak.StringP(user.FirstName),
-		LastName:      gocloak.StringP(user.LastName),
-		Email:         gocloak.StringP(user.Email),
-		Enabled:       gocloak.BoolP(true),
-		EmailVerified: gocloak.BoolP(true),
-		Credentials: &[]gocloak.CredentialRepresentation{
-			{
-				Type:      gocloak.StringP("password"),
-				Value:     gocloak.StringP(user.Password),
-				Temporary: gocloak.BoolP(false),
-			},
-		},
+	// Without a tenant claim the user's tokens would be refused everywhere
+	tenant := app.config.registration.defaultTenant
+	if invitation != nil {
+		tenant = invitation.TenantID
 	}
 
 	start := time.Now()
-	_, err = app.client.CreateUser(ctx, token.AccessToken, realm, kcuser)
+	id, err := app.identity.CreateUser(ctx, identity.NewUser{
+		Username:  user.Username,
+		Email:     user.Email,
+		Password:  user.Password,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Tenant:    tenant,
+	})
+	duration := time.Since(start).Seconds()
+	DbQueryDuration.WithLabelValues("create_user").Observe(duration)
 	if err != nil {
-		duration := time.Since(start).Seconds()
-		DbQueryDuration.WithLabelValues("create_user").Observe(duration)
 		DbQueryErrorsTotal.WithLabelValues("create_user").Inc()
-		app.logger.Error("Failed to create user in Keycloak", "error", err)
+		if invitation != nil {
+			if err := app.models.Invitations.Release(ctx, invitation.ID); err != nil {
+				app.logger.Error("Failed to release invitation", "invitation", invitation.Hint, "error", err)
+			}
+		}
+		if errors.Is(err, identity.ErrUserExists) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists", "username": user.Username})
+			return
+		}
+		app.logger.Error("Failed to create user in the identity provider", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
-	} else {
-		duration := time.Since(start).Seconds()
-		DbQueryDuration.WithLabelValues("create_user").Observe(duration)
-		UserRegistrationsTotal.WithLabelValues("success").Inc()
 	}
+	UserRegistrationsTotal.WithLabelValues("success").Inc()
+
+	roles := slices.Clone(app.config.registration.defaultRoles)
+	if invitation != nil {
+		for _, role := range invitation.Roles {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+		app.auditLog(c, "INVITATION_REDEEMED", fmt.Sprintf("Invitation %s redeemed by %s", invitation.Hint, user.Username))
+	}
+	app.rememberPassword(ctx, id, user.Password)
+	granted := app.grantRoles(ctx, id, roles)
+	app.auditLog(c, "USER_REGISTERED", fmt.Sprintf("User %s registered in tenant %s with roles %v", user.Username, tenant, granted))
 
-	c.IndentedJSON(http.StatusOK, gin.H{"message": "Data received successfully", "data": user})
+	message := "Registered, check your email to verify your address"
+	if err := app.identity.SendVerifyEmail(ctx, id); err != nil {
+		app.logger.Error("Failed to send verification email", "error", err)
+		message = "Registered, but the verification email could not be sent"
+	}
+
+	user.Password, user.InvitationCode = "", ""
+	c.IndentedJSON(http.StatusOK, gin.H{"message": message, "data": user, "roles": granted})
 
 }
 
+// grantRoles gives a new user its roles and returns the ones that were granted.
+// A failed grant is logged rather than failing a registration that already
+// created the user, an admin can assign the missing role later.
+func (app *application) grantRoles(ctx context.Context, userID string, roles []string) []string {
+	granted := []string{}
+	for _, role := range roles {
+		if err := app.identity.AddRole(ctx, userID, role); err != nil {
+			DbQueryErrorsTotal.WithLabelValues("update_user_roles").Inc()
+			app.logger.Error("Failed to grant role to new user", "role", role, "error", err)
+			continue
+		}
+		granted = append(granted, role)
+	}
+	return granted
+}
+
 func (app *application) LoginUserHandler(c *gin.Context) {
 	var req LoginRequest
 
@@ -381,22 +579,38 @@ func (app *application) LoginUserHandler(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
 	defer cancel()
 
+	// A locked account gets the same answer as a wrong password, after the same work
+	if reason, locked := app.checkLoginThrottle(c, req.Username); locked {
+		app.lockedLogin(ctx, req.Username, req.Password)
+		app.auditLogFields(c, "LOGIN_REJECTED", "Login attempt while locked out", logrus.Fields{"username": req.Username, "reason": reason})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		FailedLoginsTotal.WithLabelValues(reason).Inc()
+		return
+	}
+
 	start := time.Now()
-	token, err := app.client.Login(ctx, app.config.kc.client_id, app.config.kc.client_secret, app.config.kc.Realm, req.Username, req.Password)
+	token, err := app.identity.Login(ctx, req.Username, req.Password)
 
 	if err != nil {
 		duration := time.Since(start).Seconds()
 		DbQueryDuration.WithLabelValues("login").Observe(duration)
 		DbQueryErrorsTotal.WithLabelValues("login").Inc()
-		app.logger.Error("Failed to login to Keycloak", "error", err, "username", req.Username, "realm", app.config.kc.Realm, "client_id", app.config.kc.client_id)
+		if !errors.Is(err, identity.ErrInvalidCredentials) {
+			app.logger.Error("Failed to reach the identity provider", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		app.logger.Error("Failed to login", "error", err, "username", req.Username)
+		app.recordLoginFailure(c, req.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		FailedLoginsTotal.WithLabelValues("kc_invalid_password").Inc()
 		return
 	}
 	duration := time.Since(start).Seconds()
 	DbQueryDuration.WithLabelValues("login").Observe(duration)
+	app.resetLoginFailures(c, req.Username)
 
-	c.IndentedJSON(http.StatusOK, gin.H{"access_token": token.AccessToken, "refresh_token": token.RefreshToken, "expires_in": token.ExpiresIn, "token_type": token.TokenType})
+	c.IndentedJSON(http.StatusOK, token)
 	LoginsTotal.WithLabelValues("login").Inc()
 }
 
@@ -413,7 +627,7 @@ func (app *application) RefreshTokenHandler(c *gin.Context) {
 	defer cancel()
 
 	start := time.Now()
-	token, err := app.client.RefreshToken(ctx, reftknreq.RefreshToken, app.config.kc.client_id, app.config.kc.client_secret, app.config.kc.Realm)
+	token, err := app.identity.Refresh(ctx, reftknreq.RefreshToken)
 	if err != nil {
 		duration := time.Since(start).Seconds()
 		DbQueryDuration.WithLabelValues("refresh_token").Observe(duration)
@@ -425,14 +639,14 @@ func (app *application) RefreshTokenHandler(c *gin.Context) {
 
 	duration := time.Since(start).Seconds()
 	DbQueryDuration.WithLabelValues("refresh_token").Observe(duration)
-	c.IndentedJSON(http.StatusOK, gin.H{"access_token": token.AccessToken, "refresh_token": token.RefreshToken, "expires_in": token.ExpiresIn, "token_type": token.TokenType})
+	c.IndentedJSON(http.StatusOK, token)
 }
 
-func (app *application) PasswordResetHandler(c *gin.Context) {
-	var req PasswordChangeRequest
-
+// LogoutUserHandler ends the session of the refresh token and, when
+// the deny-list is enabled, rejects the caller's access token from now on.
+func (app *application) LogoutUserHandler(c *gin.Context) {
+	var req RefreshTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		app.logger.Error("Invalid Input", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -441,42 +655,93 @@ func (app *application) PasswordResetHandler(c *gin.Context) {
 	defer cancel()
 
 	start := time.Now()
-	adminToken, err := app.client.LoginAdmin(ctx, app.config.kc.client_id, app.config.kc.client_secret, app.config.kc.Realm)
-	if err != nil {
-		duration := time.Since(start).Seconds()
-		DbQueryDuration.WithLabelValues("login_admin").Observe(duration)
-		DbQueryErrorsTotal.WithLabelValues("login_admin").Inc()
-		app.logger.Error("Failed to login to Keycloak", "error", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to authenticate with Keycloak"})
+	err := app.identity.Logout(ctx, req.RefreshToken)
+	DbQueryDuration.WithLabelValues("logout").Observe(time.Since(start).Seconds())
+	switch {
+	case errors.Is(err, identity.ErrNotSupported):
+		// The access token can still be deny-listed below
+		app.logger.Warn("The identity provider cannot revoke refresh tokens")
+	case errors.Is(err, identity.ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refresh token"})
+		return
+	case err != nil:
+		DbQueryErrorsTotal.WithLabelValues("logout").Inc()
+		app.logger.Error("Failed to log out", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	users, err := app.client.GetUsers(ctx, adminToken.AccessToken, app.config.kc.Realm, gocloak.GetUsersParams{Username: &req.Username})
-	if err != nil || len(users) == 0 {
-		duration := time.Since(start).Seconds()
-		DbQueryDuration.WithLabelValues("get_user").Observe(duration)
-		DbQueryErrorsTotal.WithLabelValues("get_user").Inc()
-		app.logger.Error("Failed to get user from Keycloak", "error", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	if p := principalFrom(c); p != nil && app.config.revocation.denyList {
+		jti, _ := p.Claims["jti"].(string)
+		exp, _ := jwt.MapClaims(p.Claims).GetExpirationTime()
+		if jti != "" && exp != nil {
+			start := time.Now()
+			err := app.models.Revoked.Insert(c, jti, p.Subject, exp.Time)
+			DbQueryDuration.WithLabelValues("revoke_token").Observe(time.Since(start).Seconds())
+			if err != nil {
+				DbQueryErrorsTotal.WithLabelValues("revoke_token").Inc()
+				app.logger.Error("Failed to revoke access token", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+		}
+	}
+
+	app.auditLog(c, "LOGOUT", "Session ended and tokens revoked")
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// PasswordResetHandler answers the same way whether or not the account
+// exists, and before looking it up, so neither the body nor the response time
+// tells callers which usernames and addresses are registered.
+func (app *application) PasswordResetHandler(c *gin.Context) {
+	var req PasswordResetRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		app.logger.Error("Invalid Input", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	duration := time.Since(start).Seconds()
-	DbQueryDuration.WithLabelValues("get_user").Observe(duration)
 
-	User_UUID := *users[0].ID
+	app.auditLog(c, "PASSWORD_RESET_REQUESTED", "Password reset requested")
+	// The lookup outlives the request, it must not be cancelled with it
+	go app.sendPasswordReset(context.WithoutCancel(c.Request.Context()), req)
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+func (app *application) sendPasswordReset(ctx context.Context, req PasswordResetRequest) {
+	ctx, cancel := context.WithTimeout(ctx, 180*time.Second)
+	defer cancel()
 
-	ExecActionEmail := gocloak.ExecuteActionsEmail{
-		UserID:   &User_UUID,
-		ClientID: &app.config.kc.client_id,
-		Actions:  &[]string{"UPDATE_PASSWORD"},
+	start := time.Now()
+	var user *identity.User
+	var err error
+	if req.Email != "" {
+		user, err = app.identity.FindUserByEmail(ctx, req.Email)
+	} else {
+		user, err = app.identity.FindUser(ctx, req.Username)
 	}
-	err = app.client.ExecuteActionsEmail(ctx, adminToken.AccessToken, app.config.kc.Realm, ExecActionEmail)
-	if err != nil {
-		app.logger.Error("Failed to send password reset email", "error", err)
-		FailedLoginsTotal.WithLabelValues("kc_password_reset").Inc()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+	DbQueryDuration.WithLabelValues("get_user").Observe(time.Since(start).Seconds())
+	switch {
+	case errors.Is(err, identity.ErrUserNotFound):
+		return
+	case errors.Is(err, identity.ErrNotSupported):
+		app.logger.Warn("Password reset is not supported by the identity provider")
+		return
+	case err != nil:
+		DbQueryErrorsTotal.WithLabelValues("get_user").Inc()
+		app.logger.Error("Failed to get user from the identity provider", "error", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Password reset email sent successfully"})
+	if err := app.identity.SendPasswordReset(ctx, user.ID); err != nil {
+		FailedLoginsTotal.WithLabelValues("kc_password_reset").Inc()
+		app.logger.Error("Failed to send password reset email", "error", err)
+	}
+}
+
+// identityNotSupported answers requests the configured identity provider cannot serve.
+func (app *application) identityNotSupported(c *gin.Context) {
+	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not supported by the configured identity provider"})
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultInvitationTTL applies when an invitation is created without expires_at.
+const defaultInvitationTTL = 7 * 24 * time.Hour
+
+type invitationCreatedResponse struct {
+	Message    string          `json:"message"`
+	Code       string          `json:"code"`
+	Invitation data.Invitation `json:"invitation"`
+}
+
+func (app *application) CreateInvitationHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+
+	var input data.InvitationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	if input.MaxUses == 0 {
+		input.MaxUses = 1
+	}
+	// Like API keys, invitations to other tenants are for platform admins
+	if scope := adminTenant(c); input.Tenant == "" {
+		input.Tenant = c.GetString(data.TenantContextKey)
+	} else if scope != "" && input.Tenant != scope {
+		app.auditLog(c, "FORBIDDEN", fmt.Sprintf("Tenant admin of %s tried to create an invitation for tenant %s", scope, input.Tenant))
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only invite users to your own tenant"})
+		return
+	}
+	if _, err := app.tenantSettings(c.Request.Context(), input.Tenant); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tenant %s not found", input.Tenant)})
+		} else {
+			app.logger.Error("Failed to load tenant", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+	expiresAt := time.Now().Add(defaultInvitationTTL)
+	if input.ExpiresAt != nil {
+		expiresAt = *input.ExpiresAt
+	}
+
+	code, hint, hash, err := data.NewInvitationCode()
+	if err != nil {
+		app.logger.Error("Failed to generate invitation code", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	invitation := &data.Invitation{
+		Hint:      hint,
+		TenantID:  input.Tenant,
+		CodeHash:  hash,
+		Roles:     input.Roles,
+		MaxUses:   input.MaxUses,
+		CreatedBy: c.GetString(data.SubjectContextKey),
+		ExpiresAt: expiresAt,
+	}
+
+	start := time.Now()
+	err = app.models.Invitations.Insert(c, invitation)
+	DbQueryDuration.WithLabelValues("create_invitation").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("create_invitation").Inc()
+		app.logger.Error("Failed to create invitation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	app.auditLog(c, "INVITATION_CREATED", fmt.Sprintf("Invitation %s created for tenant %s granting %v for %d uses", invitation.Hint, invitation.TenantID, invitation.Roles, invitation.MaxUses))
+	// The code is only ever shown in this response
+	c.JSON(http.StatusCreated, gin.H{"message": "Invitation created", "code": code, "invitation": invitation})
+}
+
+func (app *application) ListInvitationsHandler(c *gin.Context) {
+	start := time.Now()
+	invitations, err := app.models.Invitations.List(c, adminTenant(c))
+	DbQueryDuration.WithLabelValues("list_invitations").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("list_invitations").Inc()
+		app.logger.Error("Failed to list invitations", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+func (app *application) RevokeInvitationHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id parameter"})
+		return
+	}
+
+	start := time.Now()
+	invitation, err := app.models.Invitations.Revoke(c, id, adminTenant(c))
+	DbQueryDuration.WithLabelValues("revoke_invitation").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("revoke_invitation").Inc()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Invitation %d not found", id)})
+		} else {
+			app.logger.Error("Failed to revoke invitation", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	app.auditLog(c, "INVITATION_REVOKED", fmt.Sprintf("Invitation %s revoked", invitation.Hint))
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked", "invitation": invitation})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+var errUnknownKeyID = errors.New("no signing key with this kid")
+
+// jwksCache keeps the identity provider's signing keys in memory. The set is
+// refreshed in the background; an unknown kid triggers one forced refresh so
+// that keys rotated in since the last refresh are picked up.
+type jwksCache struct {
+	url      string
+	interval time.Duration
+	// forcedEvery limits forced refreshes, tokens with made up kids must not
+	// turn into a request to the identity provider each.
+	forcedEvery time.Duration
+
+	mu         sync.RWMutex
+	set        jwk.Set
+	lastForced time.Time
+
+	// inflight is the fetch currently running, concurrent callers wait for it
+	// instead of starting their own.
+	fetchMu  sync.Mutex
+	inflight *jwksFetch
+}
+
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func newJWKSCache(url string, interval time.Duration) *jwksCache {
+	return &jwksCache{url: url, interval: interval, forcedEvery: 10 * time.Second}
+}
+
+// Start loads the key set, unless it was primed, and keeps refreshing it until
+// ctx is done. A failed first load is not fatal, the keys are fetched again on
+// the first request.
+func (j *jwksCache) Start(ctx context.Context, onError func(error)) {
+	j.mu.RLock()
+	primed := j.set != nil
+	j.mu.RUnlock()
+	if !primed {
+		if err := j.refresh(ctx); err != nil {
+			onError(err)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := j.refresh(ctx); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Key returns the raw public key for kid. An empty kid is accepted only while
+// the set holds a single key.
+func (j *jwksCache) Key(ctx context.Context, kid string) (any, error) {
+	if key, ok := j.lookup(kid); ok {
+		JwksCacheHitsTotal.Inc()
+		return raw(key)
+	}
+	JwksCacheMissesTotal.Inc()
+
+	// Without a set every request may fetch, the fetches are deduplicated anyway
+	j.mu.Lock()
+	force := j.set == nil || time.Since(j.lastForced) >= j.forcedEvery
+	if force {
+		j.lastForced = time.Now()
+	}
+	j.mu.Unlock()
+
+	if force {
+		if err := j.refresh(ctx); err != nil {
+			return nil, err
+		}
+	} else if err := j.wait(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := j.lookup(kid); ok {
+		return raw(key)
+	}
+	return nil, errUnknownKeyID
+}
+
+// Prime sets the keys without fetching them, for an issuer hosted by this
+// server that cannot be reached before it starts listening.
+func (j *jwksCache) Prime(set jwk.Set) {
+	j.mu.Lock()
+	j.set = set
+	j.mu.Unlock()
+}
+
+func (j *jwksCache) lookup(kid string) (jwk.Key, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.set == nil {
+		return nil, false
+	}
+	if kid == "" {
+		if j.set.Len() != 1 {
+			return nil, false
+		}
+		return j.set.Get(0)
+	}
+	return j.set.LookupKeyID(kid)
+}
+
+// wait blocks until the fetch in progress, if any, has finished.
+func (j *jwksCache) wait(ctx context.Context) error {
+	j.fetchMu.Lock()
+	f := j.inflight
+	j.fetchMu.Unlock()
+	if f == nil {
+		return nil
+	}
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refresh fetches the key set, or waits for the fetch already in progress.
+// On failure the previous set stays in use.
+func (j *jwksCache) refresh(ctx context.Context) error {
+	j.fetchMu.Lock()
+	if f := j.inflight; f != nil {
+		j.fetchMu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	j.inflight = f
+	j.fetchMu.Unlock()
+
+	// Not bound to ctx, the callers waiting on this fetch may outlive the one that started it
+	fetchCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	set, err := jwk.Fetch(fetchCtx, j.url)
+	cancel()
+	if err != nil {
+		f.err = fmt.Errorf("failed to fetch JWKS: %w", err)
+		JwksRefreshTotal.WithLabelValues("error").Inc()
+	} else {
+		j.mu.Lock()
+		j.set = set
+		j.mu.Unlock()
+		JwksRefreshTotal.WithLabelValues("success").Inc()
+	}
+
+	j.fetchMu.Lock()
+	j.inflight = nil
+	j.fetchMu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+func raw(key jwk.Key) (any, error) {
+	var rawKey any
+	if err := key.Raw(&rawKey); err != nil {
+		return nil, fmt.Errorf("failed to get raw key: %w", err)
+	}
+	return rawKey, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errDisallowedAlgorithm = errors.New("signing algorithm not allowed")
+	errJWKSUnavailable     = errors.New("signing keys unavailable")
+)
+
+// bearerTokenPattern is the b64token syntax of RFC 6750 section 2.1.
+var bearerTokenPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~+/]+=*$`)
+
+// parseToken verifies the token's signature and claims against the configured
+// issuer, audience and algorithms.
+func (app *application) parseToken(c *gin.Context, tokenStr string) (jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{jwt.WithLeeway(app.config.jwt.leeway), jwt.WithIssuedAt()}
+	if app.config.kc.kc_issuer_url != "" {
+		opts = append(opts, jwt.WithIssuer(app.config.kc.kc_issuer_url))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser(opts...).ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
+		// Checked before the key is looked up, so an RSA public key can never be used as an HMAC secret
+		if !slices.Contains(app.config.jwt.algorithms, token.Method.Alg()) {
+			return nil, errDisallowedAlgorithm
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := app.jwks.Key(c.Request.Context(), kid)
+		if err != nil && !errors.Is(err, errUnknownKeyID) {
+			return nil, fmt.Errorf("%w: %v", errJWKSUnavailable, err)
+		}
+		return key, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// jwt/v5 only validates exp when present
+	if exp, _ := claims.GetExpirationTime(); exp == nil {
+		return nil, fmt.Errorf("%w: exp", jwt.ErrTokenRequiredClaimMissing)
+	}
+
+	// Keycloak puts the client a token was issued to in azp, and other audiences in aud
+	if want := app.config.jwt.audience; want != "" {
+		aud, _ := claims.GetAudience()
+		azp, _ := claims["azp"].(string)
+		if !slices.Contains(aud, want) && azp != want {
+			return nil, jwt.ErrTokenInvalidAudience
+		}
+	}
+	return claims, nil
+}
+
+// tokenErrorDescription explains why a token was rejected, for the
+// error_description of the WWW-Authenticate challenge.
+func tokenErrorDescription(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "The access token is malformed"
+	case errors.Is(err, errDisallowedAlgorithm):
+		return "The access token is signed with an algorithm that is not accepted"
+	case errors.Is(err, errUnknownKeyID):
+		return "The access token is signed with an unknown key"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "The access token expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "The access token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "The access token was issued by an untrusted issuer"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "The access token was not issued for this API"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return "The access token has no expiry"
+	}
+	return "The access token signature is invalid"
+}
+
+// bearerChallenge sets the WWW-Authenticate header of RFC 6750 section 3.
+// Without an error code the client is only told that a token is needed.
+func bearerChallenge(c *gin.Context, code, description string) {
+	challenge := `Bearer realm="greenlight"`
+	if code != "" {
+		challenge += fmt.Sprintf(`, error="%s", error_description="%s"`, code, description)
+	}
+	c.Header("WWW-Authenticate", challenge)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// loginLockout configures the brute-force protection of LoginUserHandler and
+// of the current password check in UpdatePasswordHandler.
+type loginLockout struct {
+	userFailures int
+	ipFailures   int
+	window       time.Duration
+	duration     time.Duration
+	maxDelay     time.Duration
+}
+
+// loginDelayBase is the delay after the first failure, it doubles with every further one.
+const loginDelayBase = 250 * time.Millisecond
+
+func loginUserKey(username string) string { return "user:" + strings.ToLower(username) }
+
+func loginIPKey(ip string) string { return "ip:" + ip }
+
+// loginDelay is how long an attempt waits when there were recent failures.
+func (l loginLockout) loginDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := loginDelayBase << min(failures-1, 16)
+	return min(delay, l.maxDelay)
+}
+
+// checkLoginThrottle waits out the progressive delay of the username and IP
+// and reports whether either is locked out, with the metric reason.
+// Lookup failures are logged and let the attempt through.
+func (app *application) checkLoginThrottle(c *gin.Context, username string) (string, bool) {
+	attempts, err := app.models.Logins.Get(c.Request.Context(), app.config.lockout.window, loginUserKey(username), loginIPKey(c.ClientIP()))
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("login_attempts").Inc()
+		app.logger.Error("Failed to load login attempts", "error", err)
+		return "", false
+	}
+
+	now := time.Now()
+	failures, reason := 0, ""
+	for _, attempt := range attempts {
+		failures = max(failures, attempt.Failures)
+		if attempt.Locked(now) && reason == "" {
+			reason = "locked_" + strings.SplitN(attempt.Key, ":", 2)[0]
+		}
+	}
+	// Locked or not, the delay only depends on the failure count, so a locked
+	// account is not given away by answering faster than a failing one
+	select {
+	case <-time.After(app.config.lockout.loginDelay(failures)):
+	case <-c.Request.Context().Done():
+	}
+	return reason, reason != ""
+}
+
+// lockedLogin makes the identity provider round trip of a login for a locked
+// out attempt and throws the answer away, so a locked account takes as long to
+// answer as a wrong password. A session the password did open is ended in the
+// background, waiting for it would give a right password away.
+func (app *application) lockedLogin(ctx context.Context, username, password string) {
+	token, err := app.identity.Login(ctx, username, password)
+	if err != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := app.identity.Logout(ctx, token.RefreshToken); err != nil {
+			app.logger.Error("Failed to end the session of a locked out login", "error", err)
+		}
+	}()
+}
+
+// recordLoginFailure counts a wrong password against the username and the IP.
+func (app *application) recordLoginFailure(c *gin.Context, username string) {
+	lockout := app.config.lockout
+	for _, key := range []struct {
+		key, kind string
+		limit     int
+	}{
+		{loginUserKey(username), "user", lockout.userFailures},
+		{loginIPKey(c.ClientIP()), "ip", lockout.ipFailures},
+	} {
+		attempt, err := app.models.Logins.Fail(c.Request.Context(), key.key, lockout.window, key.limit, lockout.duration)
+		if err != nil {
+			DbQueryErrorsTotal.WithLabelValues("login_attempts").Inc()
+			app.logger.Error("Failed to record failed login", "error", err)
+			continue
+		}
+		if attempt.Failures == key.limit {
+			FailedLoginsTotal.WithLabelValues("lockout_" + key.kind).Inc()
+			app.auditLogFields(c, "LOGIN_LOCKED_OUT", "Too many failed logins, "+key.kind+" locked out",
+				logrus.Fields{"lockout_key": key.key, "locked_until": attempt.LockedUntil})
+		}
+	}
+}
+
+// resetLoginFailures clears the username's failures after a successful login.
+// The IP keeps its count, one hit among many guesses does not make it benign.
+func (app *application) resetLoginFailures(c *gin.Context, username string) {
+	if _, err := app.models.Logins.Reset(c.Request.Context(), loginUserKey(username)); err != nil {
+		DbQueryErrorsTotal.WithLabelValues("login_attempts").Inc()
+		app.logger.Error("Failed to reset login attempts", "error", err)
+	}
+}
+
+// startLoginAttemptCleanup prunes failures that have aged out of the window.
+func startLoginAttemptCleanup(ctx context.Context, logins data.LoginAttemptModel, window time.Duration, logger *slog.Logger) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := logins.DeleteStale(ctx, window); err != nil {
+					DbQueryErrorsTotal.WithLabelValues("login_attempts").Inc()
+					logger.Error("Failed to clean up login attempts", "error", err)
+				}
+			}
+		}
+	}()
+}
@@ -3,20 +3,23 @@ package main
 import (
 	"context"
 	"log/slog"
+	"net"
 	"os"
 	"syscall"
 	"time"
 
 	"os/signal"
 
-	"github.com/Nerzal/gocloak/v13"
 	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/Wasee3/greenlight-gin/internal/identity"
+	"github.com/Wasee3/greenlight-gin/internal/proxyproto"
+	"github.com/Wasee3/greenlight-gin/internal/ratelimit"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/time/rate"
 
 	// OpenTelemetry imports
 
 	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 const version = "1.0.0"
@@ -41,20 +44,80 @@ type config struct {
 		client_secret  string
 		kc_jwks_url    string
 		kc_issuer_url  string
+		jwksRefresh    time.Duration
 	}
 	cors struct {
 		trustedOrigins []string
 	}
+	openapi struct {
+		validate bool
+	}
+	feeds struct {
+		requireAuth bool
+		baseURL     string
+	}
+	tenant struct {
+		claim       string
+		feedDefault string
+	}
+	jwt struct {
+		audience   string
+		algorithms []string
+		leeway     time.Duration
+	}
+	revocation struct {
+		denyList bool
+	}
+	identity struct {
+		provider   string
+		oidcIssuer string
+		devIssuer  string
+		devUsers   string
+	}
+	registration struct {
+		defaultRoles  []string
+		defaultTenant string
+	}
+	tracing struct {
+		endpoint string
+	}
+	password  passwordPolicy
+	lockout   loginLockout
+	pow       powConfig
+	clientIP  clientIPConfig
+	ratelimit struct {
+		store       string
+		redisURL    string
+		timeout     time.Duration
+		maxKeys     int
+		globalRPS   float64
+		globalBurst int
+	}
+	policyFile    string
+	rateLimitFile string
+	claims        struct {
+		roles    []string
+		clientID string
+		scope    string
+	}
 }
 
 type application struct {
-	config  config
-	logger  *slog.Logger
-	models  data.Models
-	limiter *LimiterStore
-	audit   *logrus.Logger
-	client  *gocloak.GoCloak
-	tracer  oteltrace.Tracer
+	config    config
+	logger    *slog.Logger
+	models    data.Models
+	limiter   ratelimit.Store
+	global    ratelimit.Store
+	audit     *logrus.Logger
+	identity  identity.IdentityProvider
+	tracer    oteltrace.Tracer
+	openapi   *openAPISpec
+	tenants   *tenantCache
+	policy    *policyStore
+	jwks      *jwksCache
+	dev       *identity.Dev
+	pow       *powGuard
+	rateTiers *rateTierStore
 }
 
 func main() {
@@ -65,22 +128,24 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// **Initialize OpenTelemetry**
-	tp, err := initTracer(ctx)
-	if err != nil {
-		logrus.Fatal("Failed to initialize OpenTelemetry: ", err)
-	}
-	defer func() {
-		if err := tp.Shutdown(ctx); err != nil {
-			logrus.Fatal("Error shutting down tracer provider: ", err)
+	// **Initialize OpenTelemetry**, spans go nowhere with -otlp-endpoint=none
+	var tracer oteltrace.Tracer = noop.NewTracerProvider().Tracer("greenlight-api")
+	if cfg.tracing.endpoint != "none" {
+		tp, err := initTracer(ctx, cfg.tracing.endpoint)
+		if err != nil {
+			logrus.Fatal("Failed to initialize OpenTelemetry: ", err)
 		}
-	}()
+		defer func() {
+			if err := tp.Shutdown(ctx); err != nil {
+				logrus.Fatal("Error shutting down tracer provider: ", err)
+			}
+		}()
+		tracer = tp.Tracer("greenlight-api")
+	}
 
 	// Register Prometheus metrics
 	registerMetrics()
 
-	ltr := NewLimiterStore(rate.Limit(cfg.ltr_rps), cfg.ltr_burst)
-
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	db, err := openDB(cfg)
@@ -89,24 +154,110 @@ func main() {
 		os.Exit(1)
 	}
 
-	go UpdateMovieCount(db)
 	logger.Info("database connection pool established")
 
 	// Start monitoring goroutine with graceful shutdown support
 	startMonitoring(ctx, db)
 
+	limiter, globalLimiter, err := newRateLimitStore(ctx, &cfg, db, logger)
+	if err != nil {
+		logger.Error("Failed to set up the rate limit store", "error", err)
+		os.Exit(1)
+	}
+
+	policy, err := newPolicyStore(cfg.policyFile)
+	if err != nil {
+		logger.Error("Failed to load authorization policy", "error", err)
+		os.Exit(1)
+	}
+
+	rateTiers, err := newRateTierStore(cfg.rateLimitFile,
+		fixedBurst{"-limiter-burst", cfg.ltr_burst},
+		fixedBurst{"-global-burst", cfg.ratelimit.globalBurst})
+	if err != nil {
+		logger.Error("Failed to load rate limit tiers", "error", err)
+		os.Exit(1)
+	}
+
 	auditLogger := logrus.New()
-	client := gocloak.NewClient(cfg.kc.AuthURL)
+
+	idp, err := newIdentityProvider(ctx, &cfg)
+	if err != nil {
+		logger.Error("Failed to set up the identity provider", "provider", cfg.identity.provider, "error", err)
+		os.Exit(1)
+	}
+	if cfg.kc.kc_issuer_url == "" {
+		logger.Warn("No issuer URL configured, the iss claim of access tokens is not checked")
+	}
+	if cfg.feeds.baseURL == "" {
+		logger.Warn("No public base URL configured, feed links are built from the Host header")
+	}
 
 	app := &application{
-		config:  cfg,
-		logger:  logger,
-		models:  data.NewModels(db),
-		limiter: ltr,
-		audit:   auditLogger,
-		client:  client,
-		tracer:  tp.Tracer("greenlight-api"),
+		config:    cfg,
+		logger:    logger,
+		models:    data.NewModels(db),
+		limiter:   limiter,
+		global:    globalLimiter,
+		audit:     auditLogger,
+		identity:  idp,
+		tracer:    tracer,
+		tenants:   newTenantCache(30 * time.Second),
+		policy:    policy,
+		jwks:      newJWKSCache(cfg.kc.kc_jwks_url, cfg.kc.jwksRefresh),
+		rateTiers: rateTiers,
+	}
+
+	if cfg.pow.enabled {
+		app.pow, err = newPowGuard(cfg.pow)
+		if err != nil {
+			logger.Error("Failed to set up proof of work", "error", err)
+			os.Exit(1)
+		}
+		if cfg.pow.secret == "" {
+			logger.Warn("No -pow-secret given, challenges are only valid on this instance")
+		}
+	}
+
+	if cfg.revocation.denyList {
+		startRevocationCleanup(ctx, app.models.Revoked, logger)
 	}
+	app.startRoleGrantExpiry(ctx)
+	startLoginAttemptCleanup(ctx, app.models.Logins, cfg.lockout.window, logger)
+
+	// The dev issuer's keys are known here, its JWKS endpoint is only served once the router runs
+	if dev, ok := idp.(*identity.Dev); ok {
+		app.dev = dev
+		set, err := dev.KeySet()
+		if err != nil {
+			logger.Error("Failed to build the dev JWKS", "error", err)
+			os.Exit(1)
+		}
+		app.jwks.Prime(set)
+		logger.Warn("Using the development identity provider, tokens are signed with a throwaway key", "issuer", cfg.kc.kc_issuer_url)
+	}
+
+	app.jwks.Start(ctx, func(err error) {
+		logger.Error("Failed to refresh JWKS, keeping the cached keys", "error", err)
+	})
+
+	// Reload the authorization policy and rate limit tiers on SIGHUP
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := app.policy.Reload(); err != nil {
+				logger.Error("Failed to reload authorization policy, keeping the previous one", "error", err)
+			} else {
+				logger.Info("Authorization policy reloaded")
+			}
+			if err := app.rateTiers.Reload(); err != nil {
+				logger.Error("Failed to reload rate limit tiers, keeping the previous ones", "error", err)
+			} else {
+				logger.Info("Rate limit tiers reloaded")
+			}
+		}
+	}()
 
 	// Handle shutdown signals
 	go func() {
@@ -121,7 +272,16 @@ func main() {
 
 	router := app.routes()
 
-	if err := router.Run(":" + app.config.port); err != nil {
+	listener, err := net.Listen("tcp", ":"+app.config.port)
+	if err != nil {
+		logger.Error("Cannot listen on the API port", "port", app.config.port, "error", err)
+		os.Exit(1)
+	}
+	if cfg.clientIP.header == "proxy-protocol" {
+		listener = &proxyproto.Listener{Listener: listener, Trusted: cfg.clientIP.trusted, Timeout: 5 * time.Second}
+	}
+
+	if err := router.RunListener(listener); err != nil {
 		logger.Error("Cannot start the gin Router")
 	}
 }
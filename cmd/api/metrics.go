@@ -104,6 +104,58 @@ var (
 		[]string{"reason"},
 	)
 
+	RateLimitStoreErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ratelimit_store_errors_total",
+			Help: "Failed calls to the shared rate limit store, answered by the local limits instead",
+		},
+	)
+
+	RateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ratelimited_requests_total",
+			Help: "Requests rejected by a rate limit, by the scope of the limit (global, ip, route, user, tenant)",
+		},
+		[]string{"scope"},
+	)
+
+	RateLimitKeys = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ratelimit_memory_keys",
+			Help: "Keys held by the in-memory rate limits after the last cleanup",
+		},
+	)
+
+	PowChallengesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pow_challenges_total",
+			Help: "Proof of work challenges issued, solved and rejected by reason",
+		},
+		[]string{"result"},
+	)
+
+	JwksCacheHitsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jwks_cache_hits_total",
+			Help: "Token signing keys found in the cached JWKS",
+		},
+	)
+
+	JwksCacheMissesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jwks_cache_misses_total",
+			Help: "Token signing keys missing from the cached JWKS",
+		},
+	)
+
+	JwksRefreshTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwks_refresh_total",
+			Help: "JWKS downloads from the identity provider by result",
+		},
+		[]string{"result"},
+	)
+
 	GoGoroutines = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "go_goroutines",
@@ -1,113 +1,166 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/Wasee3/greenlight-gin/internal/ratelimit"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"go.opentelemetry.io/otel/attribute"
-	"golang.org/x/time/rate"
-)
-
-type ClientLimiter struct {
-	Limiter  *rate.Limiter
-	LastSeen time.Time
-}
-
-// LimiterStore manages rate limiters per client
-type LimiterStore struct {
-	clients map[string]*ClientLimiter
-	mu      sync.Mutex
-	r       rate.Limit
-	b       int
-}
-
-var (
-	globalLimiter = rate.NewLimiter(20, 50) // Global: 20 req/sec, burst 50
-	// clientStore   = NewLimiterStore(5, 10)  // Per-Client: 5 req/sec, burst 10
-	mu sync.Mutex
+	"gorm.io/gorm"
 )
 
 func (app *application) RateLimiterMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
+		route := app.rateTiers.current.Load().route(c.Request.Method, c.FullPath())
+		cost := route.cost()
+		c.Set(rateCostKey, cost)
 
-		// Global rate limiting
-		mu.Lock()
-		if !globalLimiter.Allow() {
-			mu.Unlock()
-			app.logger.Error("Global Middleware ", "error:", errors.New("too many requests"))
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Global rate limit exceeded"})
-			c.Abort()
+		// Global rate limiting, shared by every instance only through Redis
+		global := ratelimit.Limit{Rate: app.config.ratelimit.globalRPS, Burst: app.config.ratelimit.globalBurst}
+		if !app.allow(c, "global", "global", global, cost, "Global rate limit exceeded") {
 			return
 		}
-		mu.Unlock()
 
 		// Per-client rate limiting
-		limiter := app.limiter.GetLimiter(ip)
-		if !limiter.Allow() {
-			app.logger.Error("Client IP Middleware ", "error:", errors.New("too many requests"))
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests from your IP"})
-			c.Abort()
+		if !app.allow(c, "ip", "ip:"+ip, ratelimit.Limit{Rate: app.config.ltr_rps, Burst: app.config.ltr_burst}, cost, "Too many requests from your IP") {
 			return
 		}
 
+		// Routes with a limit of their own, counted in requests rather than cost
+		if route != nil && route.RPS > 0 {
+			if !app.allow(c, "route", "route:"+route.Name+":"+ip, route.limit(), 1, "Too many requests to this endpoint") {
+				return
+			}
+		}
+
 		c.Next()
 	}
 }
 
-// Middleware: Validate JWT and extract roles
-func (app *application) JWTAuthMiddleware(requiredRoles []string) gin.HandlerFunc {
+// Middleware: Authenticate the caller by JWT or API key, then authorize them against the policy
+func (app *application) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			app.auditLog(c, "UNAUTHORIZED", "Missing Authorization header")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+		var p *principal
+		if key := c.GetHeader("X-API-Key"); key != "" && c.GetHeader("Authorization") == "" {
+			p = app.authenticateAPIKey(c, key)
+		} else {
+			p = app.authenticateJWT(c)
+		}
+		if p == nil {
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		keySet, err := app.fetchJWKs(c)
-		if err != nil {
-			app.auditLog(c, "ERROR", "Failed to fetch Keycloak JWKS")
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		// Attach user info to context
+		c.Set("user", p.Username)
+		c.Set("principal", p)
+		c.Set(data.SubjectContextKey, p.Subject)
+
+		// Every movie query is scoped to this tenant by the data layer
+		if p.Tenant == "" {
+			app.auditLog(c, "FORBIDDEN", "Token carries no tenant claim")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
 			return
 		}
+		c.Set(data.TenantContextKey, p.Tenant)
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
-			key, _ := keySet.Get(0)
-			var rawKey any
-			if err := key.Raw(&rawKey); err != nil {
-				return nil, fmt.Errorf("failed to get raw key: %w", err)
-			}
-			return rawKey, nil
-		})
-		if err != nil || !token.Valid {
-			app.auditLog(c, "UNAUTHORIZED", "Invalid or expired token")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		if !app.authorize(c, p) {
+			return
+		}
+		if !app.identityRateLimit(c, p) {
+			return
+		}
+		if !app.tenantRateLimit(c, p.Tenant) {
 			return
 		}
+		c.Next()
+	}
+}
+
+// authenticateJWT validates the Bearer token. It aborts the request and
+// returns nil if the token is missing or invalid.
+func (app *application) authenticateJWT(c *gin.Context) *principal {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		app.auditLog(c, "UNAUTHORIZED", "Missing Authorization header")
+		bearerChallenge(c, "", "")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+		return nil
+	}
 
-		// Extract roles from token
-		claims, _ := token.Claims.(jwt.MapClaims)
-		realmRoles := extractRoles(claims)
+	scheme, tokenStr, ok := strings.Cut(authHeader, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || !bearerTokenPattern.MatchString(tokenStr) {
+		app.auditLog(c, "UNAUTHORIZED", "Malformed Authorization header")
+		bearerChallenge(c, "invalid_request", "The Authorization header must be Bearer followed by a token")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Malformed Authorization header"})
+		return nil
+	}
 
-		// Enforce RBAC
-		if !hasRequiredRole(realmRoles, requiredRoles) {
-			app.auditLog(c, "FORBIDDEN", "User lacks required role")
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+	claims, err := app.parseToken(c, tokenStr)
+	if errors.Is(err, errJWKSUnavailable) {
+		app.logger.Error("Failed to fetch Keycloak JWKS", "error", err)
+		app.auditLog(c, "ERROR", "Failed to fetch Keycloak JWKS")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return nil
+	}
+	if err != nil {
+		description := tokenErrorDescription(err)
+		app.auditLog(c, "UNAUTHORIZED", description)
+		bearerChallenge(c, "invalid_token", description)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return nil
+	}
+
+	if jti, _ := claims["jti"].(string); jti != "" && app.config.revocation.denyList {
+		revoked, err := app.models.Revoked.IsRevoked(c.Request.Context(), jti)
+		if err != nil {
+			DbQueryErrorsTotal.WithLabelValues("check_revoked_token").Inc()
+			app.logger.Error("Failed to check the token deny-list", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return nil
+		}
+		if revoked {
+			app.auditLog(c, "UNAUTHORIZED", "Revoked access token")
+			bearerChallenge(c, "invalid_token", "The access token has been revoked")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return nil
+		}
+	}
+
+	return app.principalFromClaims(claims)
+}
+
+// PublicTenantMiddleware picks the tenant for routes readable without a JWT
+// from the tenant= query parameter. Only tenants that turned on public feeds
+// can be picked, for the others the feed does not exist.
+func (app *application) PublicTenantMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.DefaultQuery("tenant", app.config.tenant.feedDefault)
+		if !tenantIDPattern.MatchString(id) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant parameter"})
 			return
 		}
 
-		// Attach user info to context
-		c.Set("user", claims["preferred_username"])
-		app.auditLog(c, "ACCESS_GRANTED", "User authorized")
+		tenant, err := app.tenantSettings(c.Request.Context(), id)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			app.logger.Error("Failed to load tenant", "tenant", id, "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if err != nil || !tenant.PublicFeeds {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+			return
+		}
+
+		c.Set(data.TenantContextKey, id)
 		c.Next()
 	}
 }
@@ -118,8 +171,8 @@ func (app *application) CORSMiddleware() gin.HandlerFunc {
 		origins := strings.Join(app.config.cors.trustedOrigins, ", ")
 		c.Writer.Header().Set("Access-Control-Allow-Origin", origins) // Allow all origins, change to specific domain in production
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-API-Key, X-PoW-Challenge, X-PoW-Solution")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Authorization, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true") // Allow credentials (cookies, authorization headers)
 
 		// Handle Preflight (OPTIONS request)
@@ -183,3 +236,70 @@ func (app *application) TraceMiddleware() gin.HandlerFunc {
 		)
 	}
 }
+
+// OpenAPIValidationMiddleware rejects requests whose parameters or JSON body do
+// not match the generated OpenAPI document.
+func (app *application) OpenAPIValidationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, ok := app.openapi.operations[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		var problems []string
+		params, _ := op["parameters"].([]gin.H)
+		for _, param := range params {
+			name, _ := param["name"].(string)
+			schema, _ := param["schema"].(gin.H)
+			var values []string
+			if param["in"] == "path" {
+				if raw := c.Param(name); raw != "" {
+					values = []string{raw}
+				}
+			} else {
+				values = c.QueryArray(name)
+			}
+			if len(values) == 0 {
+				if required, _ := param["required"].(bool); required {
+					problems = append(problems, name+": is required")
+				}
+				continue
+			}
+			if items, ok := schema["items"].(gin.H); ok && schema["type"] == "array" {
+				schema = items
+			}
+			for _, raw := range values {
+				problems = append(problems, app.openapi.validateParam(raw, schema, name)...)
+			}
+		}
+
+		if body, ok := op["requestBody"].(gin.H); ok && c.ContentType() == "application/json" {
+			content, _ := body["content"].(gin.H)
+			media, _ := content["application/json"].(gin.H)
+			schema, _ := media["schema"].(gin.H)
+
+			raw, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, 1048576))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				problems = append(problems, "body: malformed JSON")
+			} else {
+				problems = append(problems, app.openapi.validate(value, schema, "body")...)
+			}
+		}
+
+		if len(problems) > 0 {
+			app.logger.Error("Request failed OpenAPI validation", "path", c.FullPath(), "problems", problems)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Request does not match the API specification", "details": problems})
+			return
+		}
+
+		c.Next()
+	}
+}
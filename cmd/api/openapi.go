@@ -0,0 +1,606 @@
+package main
+
+import (
+	"embed"
+	"math"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+//go:embed openapi.html
+var openAPIDocsPage []byte
+
+// swaggerUI holds the Swagger UI assets the docs page loads, see swagger-ui/README.md.
+//
+//go:embed swagger-ui/swagger-ui-bundle.js swagger-ui/swagger-ui.css
+var swaggerUI embed.FS
+
+// routeDoc describes a single route for the OpenAPI document. Query, Body and
+// Response hold zero values of the Go types used by the handler, the schemas
+// are derived from them by reflection.
+type routeDoc struct {
+	Summary  string
+	Tag      string
+	Auth     bool
+	Query    any
+	Body     any
+	Response any
+	Status   int
+	// Negotiated routes render every format offered by ContentNegotiationMiddleware.
+	Negotiated bool
+}
+
+// Response shapes returned by the handlers, used only for documentation.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+type movieCreatedResponse struct {
+	Message string     `json:"message"`
+	Data    data.Input `json:"data"`
+}
+
+type movieUpdatedResponse struct {
+	Message string     `json:"message"`
+	Movie   data.Input `json:"movie"`
+}
+
+type movieListResponse struct {
+	Metadata data.Metadata `json:"Metadata"`
+	Movies   []data.Input  `json:"movies"`
+}
+
+type userCreatedResponse struct {
+	Message string   `json:"message"`
+	Data    User     `json:"data"`
+	Roles   []string `json:"roles"`
+}
+
+type profileResponse struct {
+	Message string  `json:"message,omitempty"`
+	User    Profile `json:"user"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+type healthcheckResponse struct {
+	Status     string `json:"status"`
+	SystemInfo struct {
+		Environment string `json:"environment"`
+		Version     string `json:"version"`
+	} `json:"system_info"`
+}
+
+// Routes without an entry here are still listed in the document, just without
+// request or response schemas.
+var routeDocs = map[string]routeDoc{
+	"GET /v1/healthcheck":                            {Summary: "Service health and version", Tag: "system", Response: healthcheckResponse{}},
+	"GET /v1/challenge":                              {Summary: "Get a proof of work challenge, its solution goes in the X-PoW-Challenge and X-PoW-Solution headers of register and login", Tag: "user", Query: ChallengeQuery{}, Response: challengeResponse{}},
+	"POST /v1/user/register":                         {Summary: "Register a new user", Tag: "user", Body: User{}, Response: userCreatedResponse{}},
+	"POST /v1/user/login":                            {Summary: "Log in and obtain tokens", Tag: "user", Body: LoginRequest{}, Response: tokenResponse{}},
+	"POST /v1/user/password/reset":                   {Summary: "Send a password reset email, the answer is the same whether or not the account exists", Tag: "user", Body: PasswordResetRequest{}, Response: messageResponse{}, Status: http.StatusAccepted},
+	"POST /v1/user/logout":                           {Summary: "End the session and revoke its tokens", Tag: "user", Auth: true, Body: RefreshTokenRequest{}, Response: messageResponse{}},
+	"GET /v1/user/me":                                {Summary: "Show the caller's profile, roles and token expiry", Tag: "user", Auth: true, Response: profileResponse{}},
+	"PUT /v1/user/me":                                {Summary: "Update the caller's name or email, a new email is verified again", Tag: "user", Auth: true, Body: ProfileUpdateRequest{}, Response: profileResponse{}},
+	"PUT /v1/user/me/password":                       {Summary: "Change the caller's password", Tag: "user", Auth: true, Body: PasswordUpdateRequest{}, Response: messageResponse{}},
+	"POST /v1/token/refresh":                         {Summary: "Refresh an access token", Tag: "user", Auth: true, Body: RefreshTokenRequest{}, Response: tokenResponse{}},
+	"GET /v1/movie/:id":                              {Summary: "Show a movie", Tag: "movies", Auth: true, Query: data.Projection{}, Response: data.Input{}, Negotiated: true},
+	"POST /v1/movie":                                 {Summary: "Create a movie", Tag: "movies", Auth: true, Body: data.Input{}, Response: movieCreatedResponse{}, Negotiated: true},
+	"GET /v1/movie":                                  {Summary: "List or search movies", Tag: "movies", Auth: true, Query: data.Filters{}, Response: movieListResponse{}, Negotiated: true},
+	"PUT /v1/movie/:id":                              {Summary: "Update a movie", Tag: "movies", Auth: true, Body: data.Update{}, Response: movieUpdatedResponse{}, Negotiated: true},
+	"DELETE /v1/movie/:id":                           {Summary: "Delete a movie", Tag: "movies", Auth: true, Response: messageResponse{}, Negotiated: true},
+	"GET /v1/feeds/movies.rss":                       {Summary: "RSS feed of recently added movies", Tag: "feeds", Query: FeedQuery{}},
+	"GET /v1/feeds/movies.atom":                      {Summary: "Atom feed of recently added movies", Tag: "feeds", Query: FeedQuery{}},
+	"POST /v1/admin/tenants":                         {Summary: "Provision a tenant", Tag: "admin", Auth: true, Body: data.TenantInput{}, Response: data.Tenant{}, Status: http.StatusCreated},
+	"GET /v1/admin/tenants":                          {Summary: "List tenants", Tag: "admin", Auth: true},
+	"GET /v1/admin/tenants/:tenant":                  {Summary: "Show a tenant", Tag: "admin", Auth: true, Response: data.Tenant{}},
+	"PUT /v1/admin/tenants/:tenant/settings":         {Summary: "Change a tenant's rate limits and allowed genres", Tag: "admin", Auth: true, Body: data.TenantSettings{}, Response: data.Tenant{}},
+	"PUT /v1/admin/movies/:id/owner":                 {Summary: "Transfer a movie to another owner", Tag: "admin", Auth: true, Body: OwnerTransferRequest{}, Response: movieUpdatedResponse{}},
+	"POST /v1/admin/api-keys":                        {Summary: "Create an API key, the key is only returned once", Tag: "admin", Auth: true, Body: data.APIKeyInput{}, Response: apiKeyCreatedResponse{}, Status: http.StatusCreated},
+	"GET /v1/admin/api-keys":                         {Summary: "List API keys", Tag: "admin", Auth: true},
+	"DELETE /v1/admin/api-keys/:id":                  {Summary: "Revoke an API key", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"POST /v1/admin/api-keys/:id/rotate":             {Summary: "Replace an API key, the old one stays valid for the overlap", Tag: "admin", Auth: true, Body: APIKeyRotateRequest{}, Response: apiKeyCreatedResponse{}, Status: http.StatusCreated},
+	"POST /v1/admin/invitations":                     {Summary: "Create an invitation code granting roles at registration, the code is only returned once", Tag: "admin", Auth: true, Body: data.InvitationInput{}, Response: invitationCreatedResponse{}, Status: http.StatusCreated},
+	"GET /v1/admin/invitations":                      {Summary: "List invitation codes", Tag: "admin", Auth: true},
+	"DELETE /v1/admin/invitations/:id":               {Summary: "Revoke an invitation code", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"GET /v1/user/me/role-requests":                  {Summary: "List your role requests", Tag: "user", Auth: true},
+	"POST /v1/user/me/role-requests":                 {Summary: "Request an additional role", Tag: "user", Auth: true, Body: data.RoleRequestInput{}, Status: http.StatusCreated},
+	"GET /v1/admin/role-requests":                    {Summary: "List role requests, pending ones unless status= says otherwise", Tag: "admin", Auth: true},
+	"POST /v1/admin/role-requests/:id/approve":       {Summary: "Approve a role request and grant the role, for a limited time if a duration is given", Tag: "admin", Auth: true, Body: data.RoleDecision{}, Response: messageResponse{}},
+	"POST /v1/admin/role-requests/:id/deny":          {Summary: "Deny a role request", Tag: "admin", Auth: true, Body: data.RoleDecision{}, Response: messageResponse{}},
+	"GET /v1/admin/users":                            {Summary: "List or search users", Tag: "admin", Auth: true, Query: UserFilters{}, Response: userListResponse{}},
+	"GET /v1/admin/users/:id":                        {Summary: "Show a user with their roles", Tag: "admin", Auth: true, Response: adminUser{}},
+	"PUT /v1/admin/users/:id/status":                 {Summary: "Enable or disable a user, disabling ends their sessions", Tag: "admin", Auth: true, Body: UserStatusRequest{}, Response: messageResponse{}},
+	"PUT /v1/admin/users/:id/roles/:role":            {Summary: "Assign the reader, writer or admin role", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"DELETE /v1/admin/users/:id/roles/:role":         {Summary: "Remove the reader, writer or admin role", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"POST /v1/admin/users/:id/logout":                {Summary: "End every session of a user", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"POST /v1/admin/users/:id/actions":               {Summary: "Email a user the required actions to perform", Tag: "admin", Auth: true, Body: RequiredActionsRequest{}, Response: messageResponse{}},
+	"POST /v1/admin/users/:id/unlock":                {Summary: "Lift a login lockout of a user", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"POST /v1/admin/policy/reload":                   {Summary: "Reload the authorization policy file", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"POST /v1/admin/ratelimits/reload":               {Summary: "Reload the rate limit tiers file", Tag: "admin", Auth: true, Response: messageResponse{}},
+	"GET /dev/oidc/.well-known/openid-configuration": {Summary: "Discovery document of the development identity provider", Tag: "dev"},
+	"GET /dev/oidc/jwks":                             {Summary: "Signing keys of the development identity provider", Tag: "dev"},
+	"POST /dev/oidc/token":                           {Summary: "Token endpoint of the development identity provider", Tag: "dev", Response: tokenResponse{}},
+	"POST /dev/oidc/revoke":                          {Summary: "Revoke a refresh token of the development identity provider", Tag: "dev"},
+	"GET /metrics":                                   {Summary: "Prometheus metrics", Tag: "system"},
+	"GET /v1/openapi.json":                           {Summary: "This document", Tag: "system"},
+	"GET /v1/docs":                                   {Summary: "Interactive API documentation", Tag: "system"},
+	"GET /v1/docs/:file":                             {Summary: "Swagger UI assets of the documentation page", Tag: "system"},
+}
+
+// openAPISpec is the generated document plus the lookups needed by the
+// validation middleware.
+type openAPISpec struct {
+	document   gin.H
+	schemas    map[string]gin.H
+	operations map[string]gin.H
+	patterns   map[string]*regexp.Regexp
+}
+
+type schemaBuilder struct {
+	schemas map[string]gin.H
+	// patterns holds every `pattern` tag compiled once, keyed by its source
+	patterns map[string]*regexp.Regexp
+}
+
+func buildOpenAPISpec(routes gin.RoutesInfo) *openAPISpec {
+	b := &schemaBuilder{schemas: map[string]gin.H{}, patterns: map[string]*regexp.Regexp{}}
+	b.schemaFor(reflect.TypeOf(errorResponse{}))
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path == routes[j].Path {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Path < routes[j].Path
+	})
+
+	paths := gin.H{}
+	operations := map[string]gin.H{}
+	for _, route := range routes {
+		path := openAPIPath(route.Path)
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		op := b.operation(route)
+		item[strings.ToLower(route.Method)] = op
+		operations[route.Method+" "+route.Path] = op
+	}
+
+	doc := gin.H{
+		"openapi": "3.1.0",
+		"info": gin.H{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": gin.H{
+			"schemas": b.schemas,
+			"securitySchemes": gin.H{
+				"bearerAuth": gin.H{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKeyAuth": gin.H{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+
+	return &openAPISpec{document: doc, schemas: b.schemas, operations: operations, patterns: b.patterns}
+}
+
+func (b *schemaBuilder) operation(route gin.RouteInfo) gin.H {
+	doc, documented := routeDocs[route.Method+" "+route.Path]
+
+	op := gin.H{"operationId": operationID(route.Method, route.Path)}
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+	if doc.Tag != "" {
+		op["tags"] = []string{doc.Tag}
+	}
+
+	var params []gin.H
+	for _, segment := range strings.Split(route.Path, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			name := segment[1:]
+			schema := gin.H{"type": "string"}
+			if name == "id" {
+				schema = gin.H{"type": "integer", "format": "int64", "minimum": 1}
+			}
+			params = append(params, gin.H{"name": name, "in": "path", "required": true, "schema": schema})
+		}
+	}
+	if doc.Query != nil {
+		params = append(params, b.queryParameters(reflect.TypeOf(doc.Query))...)
+	}
+	if doc.Negotiated {
+		formats := make([]string, 0, len(responseFormats))
+		for format := range responseFormats {
+			formats = append(formats, format)
+		}
+		sort.Strings(formats)
+		params = append(params, gin.H{"name": "format", "in": "query", "required": false, "schema": gin.H{"type": "string", "enum": formats}})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.Body != nil {
+		schema := b.schemaFor(reflect.TypeOf(doc.Body))
+		content := gin.H{binding.MIMEJSON: gin.H{"schema": schema}}
+		if doc.Negotiated {
+			for _, format := range bodyFormats {
+				content[format] = gin.H{"schema": schema}
+			}
+		}
+		op["requestBody"] = gin.H{"required": true, "content": content}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := gin.H{"description": http.StatusText(status)}
+	if doc.Response != nil {
+		schema := b.schemaFor(reflect.TypeOf(doc.Response))
+		content := gin.H{binding.MIMEJSON: gin.H{"schema": schema}}
+		if doc.Negotiated {
+			for _, format := range offeredFormats {
+				content[format] = gin.H{"schema": schema}
+			}
+		}
+		success["content"] = content
+	}
+	responses := gin.H{strconv.Itoa(status): success}
+	if documented {
+		errorRef := gin.H{"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/errorResponse"}}}
+		errorCodes := []int{http.StatusTooManyRequests, http.StatusInternalServerError}
+		if doc.Body != nil || doc.Query != nil || len(params) > 0 {
+			errorCodes = append(errorCodes, http.StatusBadRequest)
+		}
+		if doc.Auth {
+			errorCodes = append(errorCodes, http.StatusUnauthorized, http.StatusForbidden)
+		}
+		if strings.Contains(route.Path, ":id") {
+			errorCodes = append(errorCodes, http.StatusNotFound)
+		}
+		for _, code := range errorCodes {
+			responses[strconv.Itoa(code)] = gin.H{"description": http.StatusText(code), "content": errorRef}
+		}
+	}
+	op["responses"] = responses
+
+	if doc.Auth {
+		op["security"] = []gin.H{{"bearerAuth": []string{}}, {"apiKeyAuth": []string{}}}
+	}
+	return op
+}
+
+// queryParameters turns the `form` tagged fields of a struct into query parameters.
+func (b *schemaBuilder) queryParameters(t reflect.Type) []gin.H {
+	var params []gin.H
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			params = append(params, b.queryParameters(field.Type)...)
+			continue
+		}
+		name := strings.Split(field.Tag.Get("form"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		schema := b.schemaFor(field.Type)
+		required := b.applyBindingRules(schema, field)
+		params = append(params, gin.H{"name": name, "in": "query", "required": required, "schema": schema})
+	}
+	return params
+}
+
+// schemaFor returns a JSON schema for t. Named structs are stored as
+// components and referenced.
+func (b *schemaBuilder) schemaFor(t reflect.Type) gin.H {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return gin.H{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return gin.H{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return gin.H{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, exists := b.schemas[t.Name()]; !exists {
+			b.schemas[t.Name()] = gin.H{} // placeholder for recursive types
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return gin.H{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return gin.H{}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) gin.H {
+	properties := gin.H{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := b.schemaFor(field.Type)
+		if b.applyBindingRules(schema, field) {
+			required = append(required, name)
+		}
+		properties[name] = schema
+	}
+
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyBindingRules copies the validator rules from the `binding` and
+// `pattern` tags onto schema and reports whether the field is required.
+func (b *schemaBuilder) applyBindingRules(schema gin.H, field reflect.StructField) bool {
+	if pattern := field.Tag.Get("pattern"); pattern != "" {
+		schema["pattern"] = pattern
+		if _, ok := b.patterns[pattern]; !ok {
+			b.patterns[pattern] = regexp.MustCompile(pattern)
+		}
+	}
+
+	required := false
+	for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		if name == "dive" {
+			break // the remaining rules apply to the elements
+		}
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			schema["format"] = "email"
+		case "oneof":
+			schema["enum"] = strings.Fields(arg)
+		case "min", "max", "gte", "lte":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				continue
+			}
+			schema[boundKeyword(schema["type"], name)] = n
+		}
+	}
+	return required
+}
+
+func boundKeyword(schemaType any, rule string) string {
+	lower := rule == "min" || rule == "gte"
+	switch schemaType {
+	case "string":
+		if lower {
+			return "minLength"
+		}
+		return "maxLength"
+	case "array":
+		if lower {
+			return "minItems"
+		}
+		return "maxItems"
+	}
+	if lower {
+		return "minimum"
+	}
+	return "maximum"
+}
+
+// openAPIPath converts gin's `:id` path parameters to OpenAPI's `{id}`.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.TrimLeft(segment, ":*")
+		segment = strings.NewReplacer(".", "", "-", "", "_", "").Replace(segment)
+		if segment == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return b.String()
+}
+
+func (app *application) OpenAPIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.openapi.document)
+}
+
+func (app *application) APIDocsHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", openAPIDocsPage)
+}
+
+func (app *application) APIDocsAssetHandler(c *gin.Context) {
+	asset, err := swaggerUI.ReadFile("swagger-ui/" + c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	contentType := "text/css; charset=utf-8"
+	if strings.HasSuffix(c.Param("file"), ".js") {
+		contentType = "text/javascript; charset=utf-8"
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, contentType, asset)
+}
+
+// validateParam checks a raw path or query value against a parameter schema.
+func (s *openAPISpec) validateParam(raw string, schema gin.H, name string) []string {
+	var value any = raw
+	switch schema["type"] {
+	case "integer":
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return []string{name + ": must be an integer"}
+		}
+		value = float64(n)
+	case "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return []string{name + ": must be a number"}
+		}
+		value = n
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return []string{name + ": must be a boolean"}
+		}
+		value = b
+	}
+	return s.validate(value, schema, name)
+}
+
+// validate checks a decoded JSON value against the subset of JSON schema the
+// builder above produces.
+func (s *openAPISpec) validate(value any, schema gin.H, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = s.schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	}
+
+	var problems []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{path + ": must be an object"}
+		}
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, present := obj[name]; !present {
+				problems = append(problems, joinPath(path, name)+": is required")
+			}
+		}
+		properties, _ := schema["properties"].(gin.H)
+		for name, v := range obj {
+			if propSchema, ok := properties[name].(gin.H); ok {
+				problems = append(problems, s.validate(v, propSchema, joinPath(path, name))...)
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{path + ": must be an array"}
+		}
+		if n, ok := schema["minItems"].(int); ok && len(items) < n {
+			problems = append(problems, path+": must contain at least "+strconv.Itoa(n)+" items")
+		}
+		if n, ok := schema["maxItems"].(int); ok && len(items) > n {
+			problems = append(problems, path+": must contain at most "+strconv.Itoa(n)+" items")
+		}
+		itemSchema, _ := schema["items"].(gin.H)
+		for i, item := range items {
+			problems = append(problems, s.validate(item, itemSchema, path+"["+strconv.Itoa(i)+"]")...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{path + ": must be a string"}
+		}
+		length := len([]rune(str))
+		if n, ok := schema["minLength"].(int); ok && length < n {
+			problems = append(problems, path+": must be at least "+strconv.Itoa(n)+" characters")
+		}
+		if n, ok := schema["maxLength"].(int); ok && length > n {
+			problems = append(problems, path+": must be at most "+strconv.Itoa(n)+" characters")
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re := s.patterns[pattern]; re != nil && !re.MatchString(str) {
+				problems = append(problems, path+": must match "+pattern)
+			}
+		}
+		if schema["format"] == "email" {
+			if _, err := mail.ParseAddress(str); err != nil {
+				problems = append(problems, path+": must be a valid email address")
+			}
+		}
+		if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, str) {
+			problems = append(problems, path+": must be one of "+strings.Join(enum, ", "))
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return []string{path + ": must be a number"}
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			return []string{path + ": must be an integer"}
+		}
+		if min, ok := schema["minimum"].(int); ok && n < float64(min) {
+			problems = append(problems, path+": must be at least "+strconv.Itoa(min))
+		}
+		if max, ok := schema["maximum"].(int); ok && n > float64(max) {
+			problems = append(problems, path+": must be at most "+strconv.Itoa(max))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{path + ": must be a boolean"}
+		}
+	}
+	return problems
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+)
+
+// passwordPolicy is enforced by the API on every password it sets, whatever
+// policy the identity provider has on top of it.
+type passwordPolicy struct {
+	minLength int
+	maxLength int
+	// classes is how many of lower case, upper case, digits and symbols must appear
+	classes int
+	// history is how many previous passwords may not be reused, 0 turns the check off
+	history int
+}
+
+// checkPassword returns the first rule the password breaks, as a message for the user.
+func (p passwordPolicy) checkPassword(password, username, email string) string {
+	length := len([]rune(password))
+	if length < p.minLength {
+		return fmt.Sprintf("Password must be at least %d characters long", p.minLength)
+	}
+	if length > p.maxLength {
+		return fmt.Sprintf("Password must be at most %d characters long", p.maxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsControl(r):
+			return "Password must not contain control characters"
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < p.classes {
+		return fmt.Sprintf("Password must mix at least %d of lower case letters, upper case letters, digits and symbols", p.classes)
+	}
+
+	lowered := strings.ToLower(password)
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		return "Password must not contain the username"
+	}
+	if local, _, _ := strings.Cut(email, "@"); len(local) >= 3 && strings.Contains(lowered, strings.ToLower(local)) {
+		return "Password must not contain the email address"
+	}
+	return ""
+}
+
+// reusedPassword reports whether password is one of the user's last
+// passwords, as far as the history table knows them.
+func (app *application) reusedPassword(ctx context.Context, userID, password string) (bool, error) {
+	if app.config.password.history == 0 {
+		return false, nil
+	}
+	previous, err := app.models.Passwords.Recent(ctx, userID, app.config.password.history)
+	if err != nil {
+		return false, err
+	}
+	for _, hash := range previous {
+		if hash.Matches(password) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rememberPassword adds the password to the user's history. Failures are
+// only logged, the password has already been changed by then.
+func (app *application) rememberPassword(ctx context.Context, userID, password string) {
+	if app.config.password.history == 0 {
+		return
+	}
+	hash, err := data.HashPassword(userID, password)
+	if err == nil {
+		err = app.models.Passwords.Add(ctx, hash, app.config.password.history)
+	}
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("password_history").Inc()
+		app.logger.Error("Failed to record password history", "error", err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPolicy is used when no -policy-file is given. It grants the same
+// access the routes had when the role lists were hard-coded.
+//
+//go:embed policy.yaml
+var defaultPolicy []byte
+
+// principal is the authenticated caller as seen by the policy rules.
+type principal struct {
+	Subject  string
+	Username string
+	Tenant   string
+	Roles    []string
+	Scopes   []string
+	Claims   map[string]any
+}
+
+// policyFile is the on-disk format, see policy.yaml for an example.
+type policyFile struct {
+	Rules []policyRule `yaml:"rules"`
+}
+
+type policyRule struct {
+	Name   string           `yaml:"name"`
+	Routes []string         `yaml:"routes"`
+	Allow  *policyCondition `yaml:"allow"`
+	Deny   *policyCondition `yaml:"deny"`
+}
+
+// policyCondition holds when every `all` term and at least one `any` term is
+// true. An empty list places no requirement.
+type policyCondition struct {
+	All []string `yaml:"all"`
+	Any []string `yaml:"any"`
+}
+
+type policyDecision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+}
+
+// policyStore holds the active policy. Reload swaps it atomically so requests
+// in flight keep the policy they started with.
+type policyStore struct {
+	path    string
+	current atomic.Pointer[policyFile]
+}
+
+func newPolicyStore(path string) (*policyStore, error) {
+	store := &policyStore{path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *policyStore) Reload() error {
+	raw := defaultPolicy
+	if s.path != "" {
+		var err error
+		raw, err = os.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("read policy: %w", err)
+		}
+	}
+
+	var policy policyFile
+	if err := yaml.Unmarshal(raw, &policy); err != nil {
+		return fmt.Errorf("parse policy: %w", err)
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+
+	s.current.Store(&policy)
+	return nil
+}
+
+func (p *policyFile) validate() error {
+	if len(p.Rules) == 0 {
+		return errors.New("policy has no rules")
+	}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("policy rule %d has no name", i)
+		}
+		if len(rule.Routes) == 0 {
+			return fmt.Errorf("policy rule %s has no routes", rule.Name)
+		}
+		if err := validateRoutes(rule.Routes); err != nil {
+			return fmt.Errorf("policy rule %s: %w", rule.Name, err)
+		}
+		if rule.Allow == nil && rule.Deny == nil {
+			return fmt.Errorf("policy rule %s has neither allow nor deny", rule.Name)
+		}
+		for _, cond := range []*policyCondition{rule.Allow, rule.Deny} {
+			if cond == nil {
+				continue
+			}
+			for _, term := range append(slices.Clone(cond.All), cond.Any...) {
+				if _, err := parseTerm(term); err != nil {
+					return fmt.Errorf("policy rule %s: %w", rule.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Decide evaluates the rules that match the route. A matching deny wins over
+// any allow, and a route no rule allows is denied.
+func (s *policyStore) Decide(method, route string, p *principal) policyDecision {
+	policy := s.current.Load()
+
+	var matched []policyRule
+	for _, rule := range policy.Rules {
+		if rule.matches(method, route) {
+			matched = append(matched, rule)
+		}
+	}
+
+	for _, rule := range matched {
+		if rule.Deny != nil && rule.Deny.holds(p) {
+			return policyDecision{Allowed: false, Rule: rule.Name, Reason: "deny rule matched"}
+		}
+	}
+	for _, rule := range matched {
+		if rule.Allow != nil && rule.Allow.holds(p) {
+			return policyDecision{Allowed: true, Rule: rule.Name, Reason: "allow rule matched"}
+		}
+	}
+	if len(matched) == 0 {
+		return policyDecision{Allowed: false, Reason: "no rule covers this route"}
+	}
+	return policyDecision{Allowed: false, Rule: matched[0].Name, Reason: "no allow rule satisfied"}
+}
+
+// GrantsScope reports whether p already has the access the scope gives, either
+// by carrying the scope or by satisfying every allow rule that accepts it. A
+// scope no rule accepts is only granted to callers that carry it.
+func (s *policyStore) GrantsScope(p *principal, scope string) bool {
+	if slices.Contains(p.Scopes, scope) {
+		return true
+	}
+	accepted := false
+	for _, rule := range s.current.Load().Rules {
+		if rule.Allow == nil || !slices.Contains(append(slices.Clone(rule.Allow.All), rule.Allow.Any...), "scope:"+scope) {
+			continue
+		}
+		accepted = true
+		if !rule.Allow.holds(p) {
+			return false
+		}
+	}
+	return accepted
+}
+
+func (r policyRule) matches(method, route string) bool {
+	return routeMatches(r.Routes, method, route)
+}
+
+// routeMatches supports `*` as method and a trailing `*` on the path as a prefix match.
+func routeMatches(patterns []string, method, route string) bool {
+	for _, pattern := range patterns {
+		m, path, _ := strings.Cut(pattern, " ")
+		if m != "*" && !strings.EqualFold(m, method) {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(path, "*"); ok {
+			if strings.HasPrefix(route, prefix) {
+				return true
+			}
+		} else if path == route {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRoutes(routes []string) error {
+	for _, route := range routes {
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("route %q must look like \"GET /v1/movie\"", route)
+		}
+	}
+	return nil
+}
+
+func (c *policyCondition) holds(p *principal) bool {
+	for _, term := range c.All {
+		if !evalTerm(term, p) {
+			return false
+		}
+	}
+	if len(c.Any) == 0 {
+		return true
+	}
+	for _, term := range c.Any {
+		if evalTerm(term, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyTerm is one parsed expression:
+//
+//	role:writer            the caller has the role
+//	scope:movies:write     the token carries the scope
+//	tenant:acme            the caller belongs to the tenant
+//	claim:email_verified   the claim is present and not false or empty
+//	claim:locale=en        the claim equals the value
+//
+// A leading ! negates the term.
+type policyTerm struct {
+	negate bool
+	kind   string
+	name   string
+	value  string
+	equals bool
+}
+
+func parseTerm(raw string) (policyTerm, error) {
+	var t policyTerm
+	s := strings.TrimSpace(raw)
+	if rest, ok := strings.CutPrefix(s, "!"); ok {
+		t.negate = true
+		s = rest
+	}
+	kind, rest, ok := strings.Cut(s, ":")
+	if !ok || rest == "" {
+		return t, fmt.Errorf("invalid term %q", raw)
+	}
+	switch kind {
+	case "role", "scope", "tenant":
+		t.kind, t.name = kind, rest
+	case "claim":
+		t.kind = kind
+		t.name, t.value, t.equals = strings.Cut(rest, "=")
+	default:
+		return t, fmt.Errorf("invalid term %q: unknown kind %q", raw, kind)
+	}
+	return t, nil
+}
+
+func evalTerm(raw string, p *principal) bool {
+	t, err := parseTerm(raw)
+	if err != nil || p == nil {
+		return false
+	}
+
+	var result bool
+	switch t.kind {
+	case "role":
+		result = slices.Contains(p.Roles, t.name)
+	case "scope":
+		result = slices.Contains(p.Scopes, t.name)
+	case "tenant":
+		result = p.Tenant == t.name
+	case "claim":
+		value, present := p.Claims[t.name]
+		if t.equals {
+			result = present && fmt.Sprint(value) == t.value
+		} else {
+			result = present && value != nil && value != false && value != ""
+		}
+	}
+	return result != t.negate
+}
+
+// authorize applies the policy to an authenticated request and logs the
+// decision with the rule that produced it.
+func (app *application) authorize(c *gin.Context, p *principal) bool {
+	decision := app.policy.Decide(c.Request.Method, c.FullPath(), p)
+
+	action := "ACCESS_GRANTED"
+	if !decision.Allowed {
+		action = "FORBIDDEN"
+	}
+	app.auditLogFields(c, action, decision.Reason, logrus.Fields{"rule": decision.Rule})
+
+	if !decision.Allowed {
+		if c.GetHeader("Authorization") != "" {
+			bearerChallenge(c, "insufficient_scope", "The access token does not grant access to this resource")
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+		return false
+	}
+	return true
+}
+
+func (app *application) ReloadPolicyHandler(c *gin.Context) {
+	if err := app.policy.Reload(); err != nil {
+		app.logger.Error("Failed to reload policy", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	app.auditLog(c, "POLICY_RELOADED", "Authorization policy reloaded")
+	c.JSON(http.StatusOK, gin.H{"message": "Policy reloaded", "rules": len(app.policy.current.Load().Rules)})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The proof-of-work challenge protects the anonymous routes that are expensive
+// for the identity provider. A client fetches a challenge from GET
+// /v1/challenge, finds a solution such that
+//
+//	sha256(challenge + ":" + solution)
+//
+// starts with at least difficulty zero bits, and sends both in the
+// X-PoW-Challenge and X-PoW-Solution headers. Challenges are signed, so the
+// server keeps no state for them except the ones already used.
+
+var (
+	errPowMissing = errors.New("proof of work required")
+	errPowInvalid = errors.New("invalid proof of work challenge")
+	errPowExpired = errors.New("proof of work challenge expired")
+	errPowWeak    = errors.New("proof of work solution does not meet the difficulty")
+	errPowReplay  = errors.New("proof of work challenge already used")
+)
+
+// powActions are the routes a challenge can be issued for.
+var powActions = []string{"register", "login"}
+
+type powConfig struct {
+	enabled       bool
+	secret        string
+	ttl           time.Duration
+	minDifficulty int
+	maxDifficulty int
+}
+
+// powClaims is the signed payload of a challenge. It is bound to the client
+// IP and the route so it cannot be farmed out or reused elsewhere.
+type powClaims struct {
+	Nonce      string `json:"n"`
+	Action     string `json:"a"`
+	IP         string `json:"ip"`
+	Difficulty int    `json:"d"`
+	Expires    int64  `json:"exp"`
+}
+
+type ChallengeQuery struct {
+	For string `form:"for" binding:"required,oneof=register login"`
+}
+
+type challengeResponse struct {
+	Challenge  string    `json:"challenge"`
+	Algorithm  string    `json:"algorithm"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// powGuard issues and checks challenges. The used challenges and the issue
+// rates are kept per instance, a challenge can at worst be replayed once on
+// every other instance before it expires.
+type powGuard struct {
+	cfg    powConfig
+	secret []byte
+
+	mu        sync.Mutex
+	used      map[string]time.Time
+	issued    map[string]*powRate
+	lastSweep time.Time
+}
+
+// powRate counts the challenges an IP fetched in the current minute.
+type powRate struct {
+	count int
+	start time.Time
+}
+
+func newPowGuard(cfg powConfig) (*powGuard, error) {
+	secret := []byte(cfg.secret)
+	if len(secret) == 0 {
+		// Only good for a single instance, the others could not verify its challenges
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &powGuard{
+		cfg:    cfg,
+		secret: secret,
+		used:   make(map[string]time.Time),
+		issued: make(map[string]*powRate),
+	}, nil
+}
+
+// difficulty raises the minimum by one bit, doubling the expected work, for
+// every doubling of the IP's recent failed logins and of the challenges it
+// fetched in the last minute beyond the first few.
+func (g *powGuard) difficulty(failures, issued int) int {
+	d := g.cfg.minDifficulty + bits.Len(uint(failures)) + bits.Len(uint(issued/5))
+	return min(d, g.cfg.maxDifficulty)
+}
+
+// countIssue records a challenge for ip and returns how many it fetched this minute.
+func (g *powGuard) countIssue(ip string, now time.Time) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sweep(now)
+
+	rate, ok := g.issued[ip]
+	if !ok || now.Sub(rate.start) >= time.Minute {
+		rate = &powRate{start: now}
+		g.issued[ip] = rate
+	}
+	rate.count++
+	return rate.count
+}
+
+func (g *powGuard) issue(action, ip string, difficulty int, now time.Time) (string, time.Time, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, err
+	}
+	expires := now.Add(g.cfg.ttl)
+	payload, err := json.Marshal(powClaims{
+		Nonce:      hex.EncodeToString(nonce),
+		Action:     action,
+		IP:         ip,
+		Difficulty: difficulty,
+		Expires:    expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + g.sign(encoded), expires, nil
+}
+
+func (g *powGuard) sign(payload string) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature, binding, expiry and solution of a challenge
+// and marks it used.
+func (g *powGuard) verify(challenge, solution, action, ip string, now time.Time) error {
+	if challenge == "" || solution == "" {
+		return errPowMissing
+	}
+	payload, signature, ok := strings.Cut(challenge, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(g.sign(payload))) {
+		return errPowInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errPowInvalid
+	}
+	var claims powClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Action != action || claims.IP != ip {
+		return errPowInvalid
+	}
+	if now.Unix() > claims.Expires {
+		return errPowExpired
+	}
+	if len(solution) > 64 || leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) < claims.Difficulty {
+		return errPowWeak
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, seen := g.used[claims.Nonce]; seen {
+		return errPowReplay
+	}
+	g.used[claims.Nonce] = time.Unix(claims.Expires, 0)
+	return nil
+}
+
+// sweep drops expired used challenges and stale rates, at most once a minute.
+// The caller holds g.mu.
+func (g *powGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	g.lastSweep = now
+	for nonce, expires := range g.used {
+		if now.After(expires) {
+			delete(g.used, nonce)
+		}
+	}
+	for ip, rate := range g.issued {
+		if now.Sub(rate.start) >= time.Minute {
+			delete(g.issued, ip)
+		}
+	}
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+func (app *application) ChallengeHandler(c *gin.Context) {
+	if app.pow == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proof of work is not enabled"})
+		return
+	}
+
+	var query ChallengeQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "allowed": powActions})
+		return
+	}
+
+	ip, now := c.ClientIP(), time.Now()
+	failures := 0
+	attempts, err := app.models.Logins.Get(c.Request.Context(), app.config.lockout.window, loginIPKey(ip))
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("login_attempts").Inc()
+		app.logger.Error("Failed to load login attempts", "error", err)
+	}
+	for _, attempt := range attempts {
+		failures = attempt.Failures
+	}
+	difficulty := app.pow.difficulty(failures, app.pow.countIssue(ip, now))
+
+	challenge, expires, err := app.pow.issue(query.For, ip, difficulty, now)
+	if err != nil {
+		app.logger.Error("Failed to issue challenge", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	PowChallengesTotal.WithLabelValues("issued").Inc()
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, challengeResponse{Challenge: challenge, Algorithm: "sha256", Difficulty: difficulty, ExpiresAt: expires})
+}
+
+// ProofOfWorkMiddleware requires a solved challenge for action when proof of
+// work is enabled.
+func (app *application) ProofOfWorkMiddleware(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.pow == nil {
+			c.Next()
+			return
+		}
+
+		err := app.pow.verify(c.GetHeader("X-PoW-Challenge"), c.GetHeader("X-PoW-Solution"), action, c.ClientIP(), time.Now())
+		if err != nil {
+			result := map[error]string{
+				errPowMissing: "missing",
+				errPowInvalid: "invalid",
+				errPowExpired: "expired",
+				errPowWeak:    "weak",
+				errPowReplay:  "replayed",
+			}[err]
+			PowChallengesTotal.WithLabelValues(result).Inc()
+			app.auditLog(c, "POW_REJECTED", err.Error())
+			c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{"error": err.Error(), "challenge": "/v1/challenge?for=" + action})
+			return
+		}
+		PowChallengesTotal.WithLabelValues("solved").Inc()
+		c.Next()
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/identity"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// The character rule of RegisterUserHandler for names
+var namePattern = regexp.MustCompile(`^[a-zA-Z-]+$`)
+
+func (app *application) ShowProfileHandler(c *gin.Context) {
+	p := principalFrom(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	user, err := app.identity.GetUser(ctx, p.Subject)
+	DbQueryDuration.WithLabelValues("get_user").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": profile(user, p)})
+}
+
+func (app *application) UpdateProfileHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+	p := principalFrom(c)
+
+	var req ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "schema": "/v1/openapi.json#/components/schemas/ProfileUpdateRequest"})
+		return
+	}
+	if req.FirstName != nil && !namePattern.MatchString(*req.FirstName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid First Name"})
+		return
+	}
+	if req.LastName != nil && !namePattern.MatchString(*req.LastName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last Name"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	user, err := app.identity.UpdateProfile(ctx, p.Subject, identity.ProfileUpdate{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+	})
+	DbQueryDuration.WithLabelValues("update_user").Observe(time.Since(start).Seconds())
+	if errors.Is(err, identity.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email address is already in use"})
+		return
+	}
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+	app.auditLog(c, "PROFILE_UPDATED", "User updated their profile")
+
+	// A new address has to be verified again before it is trusted
+	if !user.EmailVerified {
+		if err := app.identity.SendVerifyEmail(ctx, user.ID); err != nil {
+			app.logger.Error("Failed to send verification email", "error", err)
+			c.JSON(http.StatusOK, gin.H{"message": "Profile updated, but the verification email could not be sent", "user": profile(user, p)})
+			return
+		}
+		app.auditLog(c, "EMAIL_VERIFICATION_SENT", "Verification email sent to the new address")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "user": profile(user, p)})
+}
+
+func (app *application) UpdatePasswordHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+	p := principalFrom(c)
+
+	var req PasswordUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The new password must differ from the current one"})
+		return
+	}
+	email, _ := p.Claims["email"].(string)
+	if problem := app.config.password.checkPassword(req.NewPassword, p.Username, email); problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	// Checking the current password is a login, it is throttled like one and
+	// a locked account gets the same answer as a wrong password
+	if reason, locked := app.checkLoginThrottle(c, p.Username); locked {
+		app.lockedLogin(ctx, p.Username, req.CurrentPassword)
+		app.auditLogFields(c, "PASSWORD_CHANGE_FAILED", "Password change while locked out", logrus.Fields{"reason": reason})
+		FailedLoginsTotal.WithLabelValues(reason).Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	start := time.Now()
+	err := app.identity.CheckPassword(ctx, p.Username, req.CurrentPassword)
+	DbQueryDuration.WithLabelValues("check_password").Observe(time.Since(start).Seconds())
+	if errors.Is(err, identity.ErrInvalidCredentials) {
+		app.recordLoginFailure(c, p.Username)
+		app.auditLog(c, "PASSWORD_CHANGE_FAILED", "Wrong current password")
+		FailedLoginsTotal.WithLabelValues("wrong_current_password").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+	app.resetLoginFailures(c, p.Username)
+
+	// Only now, the history would otherwise answer guesses of old passwords
+	// without the throttle, and every guess costs a hash per remembered password
+	reused, err := app.reusedPassword(ctx, p.Subject, req.NewPassword)
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("password_history").Inc()
+		app.logger.Error("Failed to check password history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if reused {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must not be one of your last %d passwords", app.config.password.history)})
+		return
+	}
+
+	start = time.Now()
+	err = app.identity.SetPassword(ctx, p.Subject, req.NewPassword)
+	DbQueryDuration.WithLabelValues("change_password").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+
+	app.rememberPassword(ctx, p.Subject, req.NewPassword)
+	app.auditLog(c, "PASSWORD_CHANGED", "User changed their password")
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// profileError answers the identity provider errors shared by the profile handlers.
+func (app *application) profileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, identity.ErrNotSupported):
+		app.identityNotSupported(c)
+	case errors.Is(err, identity.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		DbQueryErrorsTotal.WithLabelValues("identity_provider").Inc()
+		app.logger.Error("Identity provider request failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+func profile(user *identity.User, p *principal) Profile {
+	out := Profile{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Tenant:        p.Tenant,
+		Roles:         p.Roles,
+		Scopes:        p.Scopes,
+	}
+	if exp, _ := jwt.MapClaims(p.Claims).GetExpirationTime(); exp != nil {
+		out.TokenExpiresAt = exp.Time
+	}
+	return out
+}
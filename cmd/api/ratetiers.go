@@ -0,0 +1,278 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultRateLimits is used when no -ratelimit-file is given.
+//
+//go:embed ratelimits.yaml
+var defaultRateLimits []byte
+
+// rateCostKey is the gin context key the cost of the request is kept under,
+// rateReportKey the one holding the limit shown in the RateLimit headers.
+const (
+	rateCostKey   = "ratelimit_cost"
+	rateReportKey = "ratelimit_report"
+)
+
+// rateLimitFile is the on-disk format, see ratelimits.yaml for an example.
+type rateLimitFile struct {
+	Default rateLimitValue `yaml:"default"`
+	Tiers   []rateTier     `yaml:"tiers"`
+	Routes  []rateRoute    `yaml:"routes"`
+}
+
+type rateLimitValue struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+type rateTier struct {
+	Name           string   `yaml:"name"`
+	Roles          []string `yaml:"roles"`
+	rateLimitValue `yaml:",inline"`
+}
+
+type rateRoute struct {
+	Name           string   `yaml:"name"`
+	Routes         []string `yaml:"routes"`
+	Cost           int      `yaml:"cost"`
+	rateLimitValue `yaml:",inline"`
+}
+
+// rateTierStore holds the active tiers, swapped atomically on reload like the
+// authorization policy.
+type rateTierStore struct {
+	path string
+	// fixed are the limits set by flags that every request is charged to as well
+	fixed   []fixedBurst
+	current atomic.Pointer[rateLimitFile]
+}
+
+// fixedBurst is the burst of a limit outside the file, named after its flag.
+type fixedBurst struct {
+	name  string
+	burst int
+}
+
+func newRateTierStore(path string, fixed ...fixedBurst) (*rateTierStore, error) {
+	store := &rateTierStore{path: path, fixed: fixed}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *rateTierStore) Reload() error {
+	raw := defaultRateLimits
+	if s.path != "" {
+		var err error
+		raw, err = os.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("read rate limits: %w", err)
+		}
+	}
+
+	var file rateLimitFile
+	if err := yaml.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("parse rate limits: %w", err)
+	}
+	if err := file.validate(s.fixed); err != nil {
+		return err
+	}
+
+	s.current.Store(&file)
+	return nil
+}
+
+func (v rateLimitValue) limit() ratelimit.Limit {
+	return ratelimit.Limit{Rate: v.RPS, Burst: v.Burst}
+}
+
+func (v rateLimitValue) validate() error {
+	if v.RPS <= 0 || v.Burst < 1 {
+		return fmt.Errorf("rps must be above 0 and burst at least 1, got %g and %d", v.RPS, v.Burst)
+	}
+	return nil
+}
+
+func (f *rateLimitFile) validate(fixed []fixedBurst) error {
+	if err := f.Default.validate(); err != nil {
+		return fmt.Errorf("default rate limit: %w", err)
+	}
+	// A request costing more than a burst could never be made
+	smallest, smallestName := f.Default.Burst, "default"
+	for _, limit := range fixed {
+		if limit.burst < smallest {
+			smallest, smallestName = limit.burst, limit.name
+		}
+	}
+	for i, tier := range f.Tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("rate limit tier %d has no name", i)
+		}
+		if len(tier.Roles) == 0 {
+			return fmt.Errorf("rate limit tier %s has no roles", tier.Name)
+		}
+		if err := tier.validate(); err != nil {
+			return fmt.Errorf("rate limit tier %s: %w", tier.Name, err)
+		}
+		if tier.Burst < smallest {
+			smallest, smallestName = tier.Burst, "tier "+tier.Name
+		}
+	}
+	for i, route := range f.Routes {
+		if route.Name == "" {
+			return fmt.Errorf("rate limit route rule %d has no name", i)
+		}
+		if len(route.Routes) == 0 {
+			return fmt.Errorf("rate limit route rule %s has no routes", route.Name)
+		}
+		if err := validateRoutes(route.Routes); err != nil {
+			return fmt.Errorf("rate limit route rule %s: %w", route.Name, err)
+		}
+		if route.Cost < 0 || route.Cost > smallest {
+			return fmt.Errorf("rate limit route rule %s: cost must be between 1 and the smallest burst %d (%s)", route.Name, smallest, smallestName)
+		}
+		if route.RPS != 0 || route.Burst != 0 {
+			if err := route.validate(); err != nil {
+				return fmt.Errorf("rate limit route rule %s: %w", route.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// tier picks the limit of a caller with the given roles.
+func (f *rateLimitFile) tier(roles []string) ratelimit.Limit {
+	for _, tier := range f.Tiers {
+		for _, role := range tier.Roles {
+			if slices.Contains(roles, role) {
+				return tier.limit()
+			}
+		}
+	}
+	return f.Default.limit()
+}
+
+// route returns the first rule matching the route, nil if none does.
+func (f *rateLimitFile) route(method, route string) *rateRoute {
+	for i := range f.Routes {
+		if routeMatches(f.Routes[i].Routes, method, route) {
+			return &f.Routes[i]
+		}
+	}
+	return nil
+}
+
+func (r *rateRoute) cost() int {
+	if r == nil || r.Cost == 0 {
+		return 1
+	}
+	return r.Cost
+}
+
+// rateCost is the cost RateLimiterMiddleware set for the request.
+func rateCost(c *gin.Context) int {
+	if cost := c.GetInt(rateCostKey); cost > 0 {
+		return cost
+	}
+	return 1
+}
+
+// identityRateLimit applies the tier of the caller's roles, per subject or API key.
+func (app *application) identityRateLimit(c *gin.Context, p *principal) bool {
+	limit := app.rateTiers.current.Load().tier(p.Roles)
+	return app.allow(c, "user", "user:"+p.Subject, limit, rateCost(c), "Too many requests for your account")
+}
+
+// allow takes cost from the limit of key. A denied request is answered with
+// 429 and message. A store error lets the request through, the limiter must
+// not take the API down with it.
+func (app *application) allow(c *gin.Context, scope, key string, limit ratelimit.Limit, cost int, message string) bool {
+	store := app.limiter
+	if scope == "global" {
+		store = app.global
+	}
+	decision, err := store.Allow(c.Request.Context(), key, limit, cost)
+	if err != nil {
+		RateLimitStoreErrorsTotal.Inc()
+		app.logger.Error("Rate limit store failed", "key", key, "error", err)
+		return true
+	}
+
+	if !decision.Allowed {
+		RateLimitedTotal.WithLabelValues(scope).Inc()
+		app.logger.Warn("Rate limit exceeded", "scope", scope, "key", key, "cost", cost)
+		setRateLimitHeaders(c, limit, decision)
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":       message,
+			"scope":       scope,
+			"limit":       limit.Burst,
+			"retry_after": ceilSeconds(decision.RetryAfter),
+		})
+		return false
+	}
+
+	// The global limit is shared by everyone, a client learns nothing from it
+	// until it runs out
+	if scope != "global" {
+		reportRateLimit(c, limit, decision)
+	}
+	return true
+}
+
+type rateLimitReport struct {
+	limit    ratelimit.Limit
+	decision ratelimit.Decision
+}
+
+// reportRateLimit shows the limit in the response headers unless another
+// limit of the request has less remaining.
+func reportRateLimit(c *gin.Context, limit ratelimit.Limit, decision ratelimit.Decision) {
+	if prev, ok := c.Get(rateReportKey); ok && prev.(rateLimitReport).decision.Remaining <= decision.Remaining {
+		return
+	}
+	c.Set(rateReportKey, rateLimitReport{limit: limit, decision: decision})
+	setRateLimitHeaders(c, limit, decision)
+}
+
+// setRateLimitHeaders writes the RateLimit headers of the IETF draft. A
+// denied request also gets Retry-After, the seconds until it would fit.
+func setRateLimitHeaders(c *gin.Context, limit ratelimit.Limit, decision ratelimit.Decision) {
+	h := c.Writer.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.ResetAfter)))
+	if !decision.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(max(d, 0).Seconds()))
+}
+
+func (app *application) ReloadRateLimitsHandler(c *gin.Context) {
+	if err := app.rateTiers.Reload(); err != nil {
+		app.logger.Error("Failed to reload rate limits", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	file := app.rateTiers.current.Load()
+	app.auditLog(c, "RATE_LIMITS_RELOADED", "Rate limit tiers reloaded")
+	c.JSON(http.StatusOK, gin.H{"message": "Rate limits reloaded", "tiers": len(file.Tiers), "routes": len(file.Routes)})
+}
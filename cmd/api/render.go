@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+const MIMECSV = "text/csv"
+
+// responseFormats maps the format= override values to the MIME type they stand
+// for. The first MIME type of offeredFormats is used when the client accepts anything.
+var responseFormats = map[string]string{
+	"json":    binding.MIMEJSON,
+	"xml":     binding.MIMEXML,
+	"yaml":    binding.MIMEYAML2,
+	"csv":     MIMECSV,
+	"msgpack": binding.MIMEMSGPACK2,
+}
+
+var offeredFormats = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEYAML2,
+	binding.MIMEYAML,
+	MIMECSV,
+	binding.MIMEMSGPACK2,
+	binding.MIMEMSGPACK,
+}
+
+// Request bodies are accepted in the same formats, apart from CSV.
+var bodyFormats = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEYAML2,
+	binding.MIMEYAML,
+	binding.MIMEMSGPACK2,
+	binding.MIMEMSGPACK,
+}
+
+// envelope is the format independent shape of a movie response: optional
+// metadata and a message plus the movie records. Map based formats (JSON, YAML,
+// MessagePack) lay it out as an object, XML as nested elements and CSV as one
+// row per record with the metadata in response headers.
+type envelope struct {
+	Metadata any
+	Message  string
+	Key      string // name the records are stored under
+	Single   bool   // render the one record as an object rather than a list
+	Columns  []string
+	Records  []gin.H
+	Pretty   bool
+}
+
+// ContentNegotiationMiddleware picks the response format from the format=
+// override or the Accept header and checks the request body Content-Type.
+func (app *application) ContentNegotiationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var format string
+		if override := c.Query("format"); override != "" {
+			format = responseFormats[strings.ToLower(override)]
+		} else if c.GetHeader("Accept") == "" {
+			format = binding.MIMEJSON
+		} else {
+			format = c.NegotiateFormat(offeredFormats...)
+		}
+		if format == "" {
+			c.AbortWithStatusJSON(http.StatusNotAcceptable, gin.H{
+				"error":     "Unsupported response format",
+				"supported": offeredFormats,
+			})
+			return
+		}
+		c.Set("format", format)
+
+		if c.Request.ContentLength != 0 && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodDelete {
+			contentType := c.ContentType()
+			if contentType == "" {
+				contentType = binding.MIMEJSON
+			}
+			found := false
+			for _, supported := range bodyFormats {
+				if supported == contentType {
+					found = true
+					break
+				}
+			}
+			if !found {
+				c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+					"error":     "Unsupported request Content-Type",
+					"supported": bodyFormats,
+				})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// bindBody decodes the request body according to its Content-Type, defaulting
+// to JSON when none is given.
+func (app *application) bindBody(c *gin.Context, obj any) error {
+	contentType := c.ContentType()
+	if contentType == "" {
+		contentType = binding.MIMEJSON
+	}
+	return c.ShouldBindWith(obj, binding.Default(c.Request.Method, contentType))
+}
+
+// respond writes env in the format chosen by ContentNegotiationMiddleware.
+func (app *application) respond(c *gin.Context, status int, env envelope) {
+	switch format := c.GetString("format"); format {
+	case binding.MIMEXML, binding.MIMEXML2:
+		c.Render(status, xmlEnvelope{env})
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		c.YAML(status, env.object())
+	case MIMECSV:
+		app.respondCSV(c, status, env)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		c.Render(status, render.MsgPack{Data: env.object()})
+	default:
+		if env.Pretty {
+			c.IndentedJSON(status, env.object())
+		} else {
+			c.JSON(status, env.object())
+		}
+	}
+}
+
+// object lays the envelope out for the map based formats.
+func (env envelope) object() any {
+	var records any = env.Records
+	if env.Single && len(env.Records) == 1 {
+		records = env.Records[0]
+	}
+	if env.Metadata == nil && env.Message == "" && env.Single {
+		return records
+	}
+
+	out := gin.H{}
+	if env.Metadata != nil {
+		out["Metadata"] = env.Metadata
+	}
+	if env.Message != "" {
+		out["message"] = env.Message
+	}
+	if env.Key != "" {
+		out[env.Key] = records
+	}
+	return out
+}
+
+func (app *application) respondCSV(c *gin.Context, status int, env envelope) {
+	for key, value := range flattenMetadata(env.Metadata) {
+		c.Header("X-Metadata-"+key, value)
+	}
+	if env.Message != "" {
+		c.Header("X-Message", env.Message)
+	}
+	c.Header("Content-Type", MIMECSV+"; charset=utf-8")
+	c.Status(status)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(env.Columns)
+	for _, record := range env.Records {
+		row := make([]string, len(env.Columns))
+		for i, column := range env.Columns {
+			row[i] = csvValue(record[column])
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		app.logger.Error("Failed to write CSV response", "error", err)
+	}
+}
+
+// csvValue renders lists as pipe separated values and anything nested as JSON.
+func csvValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = v.Index(i).String()
+		}
+		return strings.Join(parts, "|")
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
+
+// flattenMetadata turns the metadata into header friendly key/value pairs,
+// e.g. current_page becomes Current-Page.
+func flattenMetadata(metadata any) map[string]string {
+	out := map[string]string{}
+	if metadata == nil {
+		return out
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return out
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return out
+	}
+	for key, value := range fields {
+		parts := strings.Split(key, "_")
+		for i, part := range parts {
+			if part != "" {
+				parts[i] = strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		out[strings.Join(parts, "-")] = fmt.Sprint(value)
+	}
+	return out
+}
+
+// xmlEnvelope renders an envelope as XML, keeping the column order of the records.
+type xmlEnvelope struct {
+	env envelope
+}
+
+func (r xmlEnvelope) WriteContentType(w http.ResponseWriter) {
+	if val := w.Header()["Content-Type"]; len(val) == 0 {
+		w.Header()["Content-Type"] = []string{binding.MIMEXML + "; charset=utf-8"}
+	}
+}
+
+func (r xmlEnvelope) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	enc := xml.NewEncoder(w)
+
+	root := xml.StartElement{Name: xml.Name{Local: "response"}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	if r.env.Metadata != nil {
+		if err := enc.EncodeElement(r.env.Metadata, xml.StartElement{Name: xml.Name{Local: "metadata"}}); err != nil {
+			return err
+		}
+	}
+	if r.env.Message != "" {
+		if err := enc.EncodeElement(r.env.Message, xml.StartElement{Name: xml.Name{Local: "message"}}); err != nil {
+			return err
+		}
+	}
+
+	if r.env.Key != "" || r.env.Single {
+		list := xml.StartElement{Name: xml.Name{Local: "movies"}}
+		if err := enc.EncodeToken(list); err != nil {
+			return err
+		}
+		for _, record := range r.env.Records {
+			if err := encodeXMLRecord(enc, "movie", r.env.Columns, record); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeToken(list.End()); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func encodeXMLRecord(enc *xml.Encoder, name string, columns []string, record gin.H) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		value, ok := record[column]
+		if !ok {
+			continue
+		}
+		el := xml.StartElement{Name: xml.Name{Local: column}}
+		v := reflect.ValueOf(value)
+		if v.Kind() == reflect.Slice {
+			// Lists are wrapped, e.g. <genres><genre>drama</genre></genres>
+			if err := enc.EncodeToken(el); err != nil {
+				return err
+			}
+			item := xml.StartElement{Name: xml.Name{Local: singular(column)}}
+			for i := 0; i < v.Len(); i++ {
+				if err := enc.EncodeElement(v.Index(i).Interface(), item); err != nil {
+					return err
+				}
+			}
+			if err := enc.EncodeToken(el.End()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := enc.EncodeElement(value, el); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func singular(name string) string {
+	if strings.HasSuffix(name, "s") && len(name) > 1 {
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/Wasee3/greenlight-gin/internal/identity"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// maxRoleGrant bounds time-boxed grants, longer needs should be permanent grants.
+const maxRoleGrant = 90 * 24 * time.Hour
+
+// parseGrantDuration reads the duration of a grant, empty and "0s" mean permanent.
+func parseGrantDuration(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 || d > maxRoleGrant {
+		return 0, errors.New("duration must be a duration between 0s and 2160h")
+	}
+	return d, nil
+}
+
+func (app *application) CreateRoleRequestHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+	p := principalFrom(c)
+
+	var input data.RoleRequestInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := parseGrantDuration(input.Duration); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if slices.Contains(p.Roles, input.Role) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have the " + input.Role + " role"})
+		return
+	}
+
+	request := &data.RoleRequest{
+		UserID:            p.Subject,
+		TenantID:          c.GetString(data.TenantContextKey),
+		Username:          p.Username,
+		Role:              input.Role,
+		Justification:     input.Justification,
+		RequestedDuration: input.Duration,
+		Status:            data.RoleRequestPending,
+	}
+
+	start := time.Now()
+	err := app.models.RoleRequests.Insert(c, request)
+	DbQueryDuration.WithLabelValues("create_role_request").Observe(time.Since(start).Seconds())
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "You already have a pending request for the " + input.Role + " role"})
+			return
+		}
+		DbQueryErrorsTotal.WithLabelValues("create_role_request").Inc()
+		app.logger.Error("Failed to create role request", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	app.auditLogFields(c, "ROLE_REQUESTED", "User requested the "+request.Role+" role", logrus.Fields{"role_request": request.ID, "role": request.Role})
+	c.JSON(http.StatusCreated, gin.H{"message": "Role request filed", "role_request": request})
+}
+
+func (app *application) ListMyRoleRequestsHandler(c *gin.Context) {
+	app.listRoleRequests(c, principalFrom(c).Subject, "", "")
+}
+
+func (app *application) ListRoleRequestsHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", data.RoleRequestPending)
+	if !slices.Contains([]string{data.RoleRequestPending, data.RoleRequestApproved, data.RoleRequestDenied, data.RoleRequestExpired, "all"}, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, approved, denied, expired or all"})
+		return
+	}
+	if status == "all" {
+		status = ""
+	}
+	app.listRoleRequests(c, "", adminTenant(c), status)
+}
+
+func (app *application) listRoleRequests(c *gin.Context, userID, tenant, status string) {
+	start := time.Now()
+	requests, err := app.models.RoleRequests.List(c, userID, tenant, status)
+	DbQueryDuration.WithLabelValues("list_role_requests").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("list_role_requests").Inc()
+		app.logger.Error("Failed to list role requests", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role_requests": requests})
+}
+
+func (app *application) ApproveRoleRequestHandler(c *gin.Context) {
+	request, decision, ok := app.roleRequestForDecision(c)
+	if !ok {
+		return
+	}
+
+	raw := decision.Duration
+	if raw == "" {
+		raw = request.RequestedDuration
+	}
+	duration, err := parseGrantDuration(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var expiresAt *time.Time
+	if duration > 0 {
+		expiry := time.Now().Add(duration)
+		expiresAt = &expiry
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 180*time.Second)
+	defer cancel()
+
+	// A grant for a role the user already holds would take it away on expiry
+	roles, err := app.identity.UserRoles(ctx, request.UserID)
+	if err != nil {
+		app.profileError(c, err)
+		return
+	}
+	if slices.Contains(roles, request.Role) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already has the " + request.Role + " role"})
+		return
+	}
+
+	request, ok = app.decideRoleRequest(c, request.ID, data.RoleRequestApproved, decision.Note, expiresAt)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	err = app.identity.AddRole(ctx, request.UserID, request.Role)
+	DbQueryDuration.WithLabelValues("update_user_roles").Observe(time.Since(start).Seconds())
+	if err != nil {
+		if err := app.models.RoleRequests.Reopen(ctx, request.ID); err != nil {
+			app.logger.Error("Failed to reopen role request", "role_request", request.ID, "error", err)
+		}
+		app.profileError(c, err)
+		return
+	}
+
+	fields := logrus.Fields{"role_request": request.ID, "target_user": request.UserID, "role": request.Role}
+	message := fmt.Sprintf("Role %s granted to %s", request.Role, request.Username)
+	if expiresAt != nil {
+		fields["expires_at"] = expiresAt
+		message += " until " + expiresAt.Format(time.RFC3339)
+	}
+	app.auditLogFields(c, "ROLE_REQUEST_APPROVED", message, fields)
+	c.JSON(http.StatusOK, gin.H{"message": message, "role_request": request})
+}
+
+func (app *application) DenyRoleRequestHandler(c *gin.Context) {
+	request, decision, ok := app.roleRequestForDecision(c)
+	if !ok {
+		return
+	}
+
+	request, ok = app.decideRoleRequest(c, request.ID, data.RoleRequestDenied, decision.Note, nil)
+	if !ok {
+		return
+	}
+
+	app.auditLogFields(c, "ROLE_REQUEST_DENIED", fmt.Sprintf("Role %s denied to %s", request.Role, request.Username),
+		logrus.Fields{"role_request": request.ID, "target_user": request.UserID, "role": request.Role})
+	c.JSON(http.StatusOK, gin.H{"message": "Role request denied", "role_request": request})
+}
+
+// roleRequestForDecision loads the request named in the path and the optional
+// decision body. Admins may not decide their own requests, and tenant admins
+// only those of their own tenant.
+func (app *application) roleRequestForDecision(c *gin.Context) (*data.RoleRequest, data.RoleDecision, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+
+	var decision data.RoleDecision
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id parameter"})
+		return nil, decision, false
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&decision); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, decision, false
+		}
+	}
+
+	start := time.Now()
+	request, err := app.models.RoleRequests.Get(c, id, adminTenant(c))
+	DbQueryDuration.WithLabelValues("get_role_request").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.roleRequestError(c, id, err)
+		return nil, decision, false
+	}
+	if request.Status != data.RoleRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role request is already " + request.Status})
+		return nil, decision, false
+	}
+	if request.UserID == principalFrom(c).Subject {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot decide your own role request"})
+		return nil, decision, false
+	}
+	return request, decision, true
+}
+
+func (app *application) decideRoleRequest(c *gin.Context, id int64, status, note string, expiresAt *time.Time) (*data.RoleRequest, bool) {
+	start := time.Now()
+	request, err := app.models.RoleRequests.Decide(c, id, status, c.GetString(data.SubjectContextKey), note, expiresAt)
+	DbQueryDuration.WithLabelValues("decide_role_request").Observe(time.Since(start).Seconds())
+	if err != nil {
+		app.roleRequestError(c, id, err)
+		return nil, false
+	}
+	return request, true
+}
+
+func (app *application) roleRequestError(c *gin.Context, id int64, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Role request %d not found", id)})
+	case errors.Is(err, data.ErrRequestDecided):
+		c.JSON(http.StatusConflict, gin.H{"error": "Role request was already decided"})
+	default:
+		DbQueryErrorsTotal.WithLabelValues("role_request").Inc()
+		app.logger.Error("Failed to load role request", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// startRoleGrantExpiry takes back time-boxed roles once they expire. A grant
+// whose removal fails stays approved and is retried on the next pass.
+func (app *application) startRoleGrantExpiry(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				app.expireRoleGrants(ctx)
+			}
+		}
+	}()
+}
+
+func (app *application) expireRoleGrants(ctx context.Context) {
+	due, err := app.models.RoleRequests.DueForExpiry(ctx)
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("expire_role_grants").Inc()
+		app.logger.Error("Failed to load expired role grants", "error", err)
+		return
+	}
+
+	for _, request := range due {
+		err := app.identity.RemoveRole(ctx, request.UserID, request.Role)
+		if err != nil && !errors.Is(err, identity.ErrUserNotFound) {
+			app.logger.Error("Failed to remove expired role", "role_request", request.ID, "role", request.Role, "error", err)
+			continue
+		}
+		if err := app.models.RoleRequests.MarkExpired(ctx, request.ID); err != nil {
+			DbQueryErrorsTotal.WithLabelValues("expire_role_grants").Inc()
+			app.logger.Error("Failed to mark role grant expired", "role_request", request.ID, "error", err)
+			continue
+		}
+		// There is no request to take the method and path from
+		app.audit.WithFields(logrus.Fields{
+			"action":       "ROLE_GRANT_EXPIRED",
+			"message":      fmt.Sprintf("Role %s of %s expired", request.Role, request.Username),
+			"role_request": request.ID,
+			"target_user":  request.UserID,
+			"role":         request.Role,
+		}).Info()
+	}
+}
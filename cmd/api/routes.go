@@ -8,19 +8,83 @@ import (
 func (app *application) routes() *gin.Engine {
 
 	router := gin.Default()
+	// ClientIPMiddleware decides which proxies to believe, gin must not second guess it
+	if err := router.SetTrustedProxies(nil); err != nil {
+		app.logger.Error("Failed to reset the trusted proxies", "error", err)
+	}
 
-	router.Use(app.RateLimiterMiddleware(), app.TraceMiddleware())
+	router.Use(app.ClientIPMiddleware(), app.RateLimiterMiddleware(), app.TraceMiddleware())
+	if app.config.openapi.validate {
+		router.Use(app.OpenAPIValidationMiddleware())
+	}
 	router.GET("/v1/healthcheck", app.healthcheckHandler)
-	router.POST("/v1/user/register", app.RegisterUserHandler)
-	router.POST("/v1/user/login", app.LoginUserHandler)
+	router.GET("/v1/challenge", app.ChallengeHandler)
+	router.POST("/v1/user/register", app.ProofOfWorkMiddleware("register"), app.RegisterUserHandler)
+	router.POST("/v1/user/login", app.ProofOfWorkMiddleware("login"), app.LoginUserHandler)
 	router.POST("/v1/user/password/reset", app.PasswordResetHandler)
 
-	router.GET("/v1/movie/:id", app.JWTAuthMiddleware([]string{"reader"}), app.ShowMovieHandler)
-	router.POST("/v1/movie", app.JWTAuthMiddleware([]string{"writer"}), app.CreateMovieHandler)
-	router.GET("/v1/movie", app.JWTAuthMiddleware([]string{"reader"}), app.ListMovieHandler)
-	router.PUT("/v1/movie/:id", app.JWTAuthMiddleware([]string{"writer"}), app.UpdateMovieHandler)
-	router.DELETE("/v1/movie/:id", app.JWTAuthMiddleware([]string{"writer"}), app.DeleteMovieHandler)
-	router.POST("/v1/token/refresh", app.JWTAuthMiddleware([]string{"writer"}), app.RefreshTokenHandler)
+	router.GET("/v1/movie/:id", app.AuthMiddleware(), app.ContentNegotiationMiddleware(), app.ShowMovieHandler)
+	router.POST("/v1/movie", app.AuthMiddleware(), app.ContentNegotiationMiddleware(), app.CreateMovieHandler)
+	router.GET("/v1/movie", app.AuthMiddleware(), app.ContentNegotiationMiddleware(), app.ListMovieHandler)
+	router.PUT("/v1/movie/:id", app.AuthMiddleware(), app.ContentNegotiationMiddleware(), app.UpdateMovieHandler)
+	router.DELETE("/v1/movie/:id", app.AuthMiddleware(), app.ContentNegotiationMiddleware(), app.DeleteMovieHandler)
+	router.POST("/v1/token/refresh", app.AuthMiddleware(), app.RefreshTokenHandler)
+	router.POST("/v1/user/logout", app.AuthMiddleware(), app.LogoutUserHandler)
+	router.GET("/v1/user/me", app.AuthMiddleware(), app.ShowProfileHandler)
+	router.PUT("/v1/user/me", app.AuthMiddleware(), app.UpdateProfileHandler)
+	router.PUT("/v1/user/me/password", app.AuthMiddleware(), app.UpdatePasswordHandler)
+	router.GET("/v1/user/me/role-requests", app.AuthMiddleware(), app.ListMyRoleRequestsHandler)
+	router.POST("/v1/user/me/role-requests", app.AuthMiddleware(), app.CreateRoleRequestHandler)
+	feeds := router.Group("/v1/feeds")
+	if app.config.feeds.requireAuth {
+		feeds.Use(app.AuthMiddleware())
+	} else {
+		feeds.Use(app.PublicTenantMiddleware())
+	}
+	feeds.GET("/movies.rss", app.MoviesRSSHandler)
+	feeds.GET("/movies.atom", app.MoviesAtomHandler)
+
+	admin := router.Group("/v1/admin", app.AuthMiddleware())
+	admin.POST("/tenants", app.CreateTenantHandler)
+	admin.GET("/tenants", app.ListTenantsHandler)
+	admin.GET("/tenants/:tenant", app.ShowTenantHandler)
+	admin.PUT("/tenants/:tenant/settings", app.UpdateTenantSettingsHandler)
+	admin.PUT("/movies/:id/owner", app.TransferMovieOwnerHandler)
+	admin.POST("/policy/reload", app.ReloadPolicyHandler)
+	admin.POST("/ratelimits/reload", app.ReloadRateLimitsHandler)
+	admin.POST("/api-keys", app.CreateAPIKeyHandler)
+	admin.GET("/api-keys", app.ListAPIKeysHandler)
+	admin.DELETE("/api-keys/:id", app.RevokeAPIKeyHandler)
+	admin.POST("/api-keys/:id/rotate", app.RotateAPIKeyHandler)
+	admin.POST("/invitations", app.CreateInvitationHandler)
+	admin.GET("/invitations", app.ListInvitationsHandler)
+	admin.DELETE("/invitations/:id", app.RevokeInvitationHandler)
+	admin.GET("/role-requests", app.ListRoleRequestsHandler)
+	admin.POST("/role-requests/:id/approve", app.ApproveRoleRequestHandler)
+	admin.POST("/role-requests/:id/deny", app.DenyRoleRequestHandler)
+	admin.GET("/users", app.ListUsersHandler)
+	admin.GET("/users/:id", app.ShowUserHandler)
+	admin.PUT("/users/:id/status", app.UpdateUserStatusHandler)
+	admin.PUT("/users/:id/roles/:role", app.AssignUserRoleHandler)
+	admin.DELETE("/users/:id/roles/:role", app.RemoveUserRoleHandler)
+	admin.POST("/users/:id/logout", app.EndUserSessionsHandler)
+	admin.POST("/users/:id/actions", app.RequireUserActionsHandler)
+	admin.POST("/users/:id/unlock", app.UnlockUserHandler)
+
+	if app.dev != nil {
+		dev := router.Group("/dev/oidc")
+		dev.GET("/.well-known/openid-configuration", app.DevDiscoveryHandler)
+		dev.GET("/jwks", app.DevJWKSHandler)
+		dev.POST("/token", app.DevTokenHandler)
+		dev.POST("/revoke", app.DevRevokeHandler)
+	}
+
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/v1/openapi.json", app.OpenAPIHandler)
+	router.GET("/v1/docs", app.APIDocsHandler)
+	router.GET("/v1/docs/:file", app.APIDocsAssetHandler)
+
+	// Built last so that every route registered above is part of the document
+	app.openapi = buildOpenAPISpec(router.Routes())
 	return router
 }
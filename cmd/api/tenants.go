@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Wasee3/greenlight-gin/internal/data"
+	"github.com/Wasee3/greenlight-gin/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]+$`)
+
+// platformAdminRole is held by the operators of the deployment. The admin role
+// only reaches the caller's own tenant, this one reaches every tenant.
+const platformAdminRole = "platform-admin"
+
+// adminTenant is the tenant an admin request is confined to, empty for a
+// platform admin who may act on every tenant.
+func adminTenant(c *gin.Context) string {
+	if p := principalFrom(c); p != nil && slices.Contains(p.Roles, platformAdminRole) {
+		return ""
+	}
+	return c.GetString(data.TenantContextKey)
+}
+
+// ownTenant rejects requests of a tenant admin naming another tenant.
+func (app *application) ownTenant(c *gin.Context, id string) bool {
+	if scope := adminTenant(c); scope != "" && scope != id {
+		app.auditLog(c, "FORBIDDEN", "Tenant admin of "+scope+" addressed tenant "+id)
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only manage your own tenant"})
+		return false
+	}
+	return true
+}
+
+// tenantCache keeps tenant settings for a short while so that the rate limit
+// and genre checks don't hit the database on every request.
+type tenantCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedTenant
+}
+
+type cachedTenant struct {
+	tenant  *data.Tenant
+	fetched time.Time
+}
+
+func newTenantCache(ttl time.Duration) *tenantCache {
+	return &tenantCache{ttl: ttl, entries: make(map[string]cachedTenant)}
+}
+
+func (app *application) tenantSettings(ctx context.Context, id string) (*data.Tenant, error) {
+	app.tenants.mu.Lock()
+	entry, ok := app.tenants.entries[id]
+	app.tenants.mu.Unlock()
+	if ok && time.Since(entry.fetched) < app.tenants.ttl {
+		return entry.tenant, nil
+	}
+
+	tenant, err := app.models.Tenants.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	app.tenants.mu.Lock()
+	app.tenants.entries[id] = cachedTenant{tenant: tenant, fetched: time.Now()}
+	app.tenants.mu.Unlock()
+	return tenant, nil
+}
+
+func (app *application) forgetTenant(id string) {
+	app.tenants.mu.Lock()
+	delete(app.tenants.entries, id)
+	app.tenants.mu.Unlock()
+}
+
+// tenantRateLimit applies the tenant's own limiter on top of the per-IP one.
+// It also rejects tokens naming a tenant that was never provisioned.
+func (app *application) tenantRateLimit(c *gin.Context, id string) bool {
+	tenant, err := app.tenantSettings(c.Request.Context(), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		app.auditLog(c, "FORBIDDEN", "Unknown tenant "+id)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+		return false
+	}
+	if err != nil {
+		app.logger.Error("Failed to load tenant", "tenant", id, "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+
+	if tenant.RateLimitRPS == nil {
+		return true
+	}
+	burst := app.config.ltr_burst
+	if tenant.RateLimitBurst != nil {
+		burst = *tenant.RateLimitBurst
+	}
+	return app.allow(c, "tenant", "tenant:"+id, ratelimit.Limit{Rate: *tenant.RateLimitRPS, Burst: burst}, rateCost(c), "Tenant rate limit exceeded")
+}
+
+// checkTenantGenres rejects genres outside the tenant's allow-list, if it has one.
+func (app *application) checkTenantGenres(c *gin.Context, genres []string) bool {
+	tenant, err := app.tenantSettings(c.Request.Context(), c.GetString(data.TenantContextKey))
+	if err != nil {
+		app.logger.Error("Failed to load tenant", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+	if len(tenant.AllowedGenres) == 0 {
+		return true
+	}
+	for _, genre := range genres {
+		if !slices.Contains(tenant.AllowedGenres, genre) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Genre %q is not allowed", genre), "allowed_genres": tenant.AllowedGenres})
+			return false
+		}
+	}
+	return true
+}
+
+func (app *application) CreateTenantHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+
+	var input data.TenantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !tenantIDPattern.MatchString(input.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant id"})
+		return
+	}
+
+	tenant := &data.Tenant{
+		ID:             input.ID,
+		Name:           input.Name,
+		RateLimitRPS:   input.RateLimitRPS,
+		RateLimitBurst: input.RateLimitBurst,
+		AllowedGenres:  input.AllowedGenres,
+		PublicFeeds:    input.PublicFeeds,
+		Version:        1,
+	}
+
+	start := time.Now()
+	err := app.models.Tenants.Insert(c, tenant)
+	DbQueryDuration.WithLabelValues("create_tenant").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("create_tenant").Inc()
+		if errors.Is(err, data.ErrDuplicateKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tenant already exists"})
+			return
+		}
+		app.logger.Error("Failed to create tenant", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	app.auditLog(c, "TENANT_CREATED", "Tenant "+tenant.ID+" provisioned")
+	c.JSON(http.StatusCreated, gin.H{"message": "Tenant created", "tenant": tenant})
+}
+
+func (app *application) ListTenantsHandler(c *gin.Context) {
+	start := time.Now()
+	tenants, err := app.models.Tenants.List(c)
+	DbQueryDuration.WithLabelValues("list_tenants").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("list_tenants").Inc()
+		app.logger.Error("Failed to list tenants", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants})
+}
+
+func (app *application) ShowTenantHandler(c *gin.Context) {
+	id := c.Param("tenant")
+	if !app.ownTenant(c, id) {
+		return
+	}
+
+	start := time.Now()
+	tenant, err := app.models.Tenants.Get(c.Request.Context(), id)
+	DbQueryDuration.WithLabelValues("get_tenant").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("get_tenant").Inc()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Tenant %s not found", id)})
+		} else {
+			app.logger.Error("Failed to get tenant", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant": tenant})
+}
+
+func (app *application) UpdateTenantSettingsHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1048576)
+	id := c.Param("tenant")
+	if !app.ownTenant(c, id) {
+		return
+	}
+
+	var settings data.TenantSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start := time.Now()
+	tenant, err := app.models.Tenants.UpdateSettings(c, id, settings)
+	DbQueryDuration.WithLabelValues("update_tenant").Observe(time.Since(start).Seconds())
+	if err != nil {
+		DbQueryErrorsTotal.WithLabelValues("update_tenant").Inc()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Tenant %s not found", id)})
+		} else {
+			app.logger.Error("Failed to update tenant", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	app.forgetTenant(id)
+	app.auditLog(c, "TENANT_UPDATED", "Settings of tenant "+id+" changed")
+	c.JSON(http.StatusOK, gin.H{"message": "Tenant settings updated", "tenant": tenant})
+}
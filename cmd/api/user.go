@@ -1,11 +1,15 @@
 package main
 
+import "time"
+
 type User struct {
-	Username  string `json:"username" binding:"required,min=2,max=20"`
+	Username  string `json:"username" binding:"required,min=2,max=20" pattern:"^[a-zA-Z0-9_]+$"`
 	Email     string `json:"email" binding:"required,email,max=40"`
-	Password  string `json:"password" binding:"required,min=10,max=20"`
-	FirstName string `json:"first_name" binding:"required,min=2,max=20"`
-	LastName  string `json:"last_name" binding:"required,min=2,max=20"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name" binding:"required,min=2,max=20" pattern:"^[a-zA-Z-]+$"`
+	LastName  string `json:"last_name" binding:"required,min=2,max=20" pattern:"^[a-zA-Z-]+$"`
+	// InvitationCode grants the roles of an invitation created by an admin
+	InvitationCode string `json:"invitation_code,omitempty" binding:"omitempty,max=64"`
 }
 
 type LoginRequest struct {
@@ -17,6 +21,34 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
-type PasswordChangeRequest struct {
-	Username string `json:"username" binding:"required"`
+// PasswordResetRequest names the account by username or by email address.
+type PasswordResetRequest struct {
+	Username string `json:"username" binding:"required_without=Email"`
+	Email    string `json:"email" binding:"omitempty,email"`
+}
+
+// ProfileUpdateRequest changes the caller's profile, omitted fields are kept.
+type ProfileUpdateRequest struct {
+	FirstName *string `json:"first_name" binding:"omitempty,min=2,max=20" pattern:"^[a-zA-Z-]+$"`
+	LastName  *string `json:"last_name" binding:"omitempty,min=2,max=20" pattern:"^[a-zA-Z-]+$"`
+	Email     *string `json:"email" binding:"omitempty,email,max=40"`
+}
+
+type PasswordUpdateRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// Profile is the caller's account together with what their token grants.
+type Profile struct {
+	ID             string    `json:"id"`
+	Username       string    `json:"username"`
+	Email          string    `json:"email"`
+	EmailVerified  bool      `json:"email_verified"`
+	FirstName      string    `json:"first_name"`
+	LastName       string    `json:"last_name"`
+	Tenant         string    `json:"tenant"`
+	Roles          []string  `json:"roles"`
+	Scopes         []string  `json:"scopes"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
 }
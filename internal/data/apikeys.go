@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// apiKeyScheme starts every key so that leaked keys are easy to spot in logs
+// and by secret scanners.
+const apiKeyScheme = "glk"
+
+type APIKey struct {
+	ID         int64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	Prefix     string         `json:"prefix" gorm:"not null"`
+	KeyHash    []byte         `json:"-" gorm:"not null"`
+	Name       string         `json:"name" gorm:"not null"`
+	TenantID   string         `json:"tenant_id" gorm:"not null"`
+	Roles      pq.StringArray `json:"roles" gorm:"type:text[]"`
+	Scopes     pq.StringArray `json:"scopes" gorm:"type:text[]"`
+	CreatedBy  string         `json:"created_by"`
+	CreatedAt  time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	RevokedAt  *time.Time     `json:"revoked_at"`
+	ReplacedBy *int64         `json:"replaced_by"`
+}
+
+type APIKeyInput struct {
+	Name      string     `json:"name" binding:"required,min=1,max=100"`
+	Tenant    string     `json:"tenant" binding:"omitempty,min=2,max=63" pattern:"^[a-z0-9][a-z0-9-]+$"`
+	Roles     []string   `json:"roles" binding:"omitempty,dive,min=1"`
+	Scopes    []string   `json:"scopes" binding:"omitempty,dive,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Active reports whether the key may still be used at now.
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// NewAPIKeySecret returns a fresh key of the form glk_<prefix>_<secret>
+// together with its prefix and hash. The key itself is never stored.
+func NewAPIKeySecret() (key, prefix string, hash []byte, err error) {
+	random := make([]byte, 6+32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", nil, err
+	}
+	prefix = hex.EncodeToString(random[:6])
+	key = apiKeyScheme + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(random[6:])
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// ParseAPIKey returns the prefix of a well formed key.
+func ParseAPIKey(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyScheme || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// Matches compares the key against the stored hash in constant time.
+func (k *APIKey) Matches(key string) bool {
+	return subtle.ConstantTimeCompare(k.KeyHash, HashAPIKey(key)) == 1
+}
+
+// API keys are managed by admins across tenants, so like tenants they are not
+// subject to the row-level security of the movies table.
+type APIKeyModel struct {
+	db *gorm.DB
+}
+
+func (m APIKeyModel) Insert(c *gin.Context, key *APIKey) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	err := m.db.WithContext(ctx).Create(key).Error
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (m APIKeyModel) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var key APIKey
+	if err := m.db.WithContext(ctx).First(&key, "prefix = ?", prefix).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// List returns the keys of a tenant, or of every tenant when tenant is empty.
+func (m APIKeyModel) List(c *gin.Context, tenant string) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	query := m.db.WithContext(ctx).Order("id")
+	if tenant != "" {
+		query = query.Where("tenant_id = ?", tenant)
+	}
+	var keys []APIKey
+	if err := query.Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke disables the key immediately. Revoking a revoked key is not an error.
+// A non-empty tenant restricts it to that tenant's keys, others are not found.
+func (m APIKeyModel) Revoke(c *gin.Context, id int64, tenant string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockKey(tx, tenant).First(&key, id).Error; err != nil {
+			return err
+		}
+		if key.RevokedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		key.RevokedAt = &now
+		return tx.Model(&key).Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Rotate stores replacement as the successor of key id, copying its name,
+// tenant, roles, scopes and expiry. The old key keeps working until now+overlap
+// so that clients can switch over, or until it expires if that is earlier.
+// A non-empty tenant restricts it to that tenant's keys.
+func (m APIKeyModel) Rotate(c *gin.Context, id int64, tenant string, replacement *APIKey, overlap time.Duration) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	var old APIKey
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockKey(tx, tenant).First(&old, id).Error; err != nil {
+			return err
+		}
+		if !old.Active(time.Now()) {
+			return ErrKeyInactive
+		}
+
+		replacement.Name = old.Name
+		replacement.TenantID = old.TenantID
+		replacement.Roles = old.Roles
+		replacement.Scopes = old.Scopes
+		replacement.ExpiresAt = old.ExpiresAt
+		if err := tx.Create(replacement).Error; err != nil {
+			if isUniqueViolation(err) {
+				return ErrDuplicateKey
+			}
+			return err
+		}
+
+		graceEnd := time.Now().Add(overlap)
+		if old.ExpiresAt == nil || graceEnd.Before(*old.ExpiresAt) {
+			old.ExpiresAt = &graceEnd
+		}
+		old.ReplacedBy = &replacement.ID
+		return tx.Model(&old).Updates(map[string]any{
+			"expires_at":  old.ExpiresAt,
+			"replaced_by": old.ReplacedBy,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// lockKey selects a key FOR UPDATE, limited to the tenant unless it is empty,
+// so that revoking and rotating the same key don't race each other.
+func lockKey(tx *gorm.DB, tenant string) *gorm.DB {
+	tx = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	if tenant != "" {
+		tx = tx.Where("tenant_id = ?", tenant)
+	}
+	return tx
+}
+
+// Touch records that the key was used. Writes are limited to one a minute per
+// key so that busy clients don't turn every request into an UPDATE.
+func (m APIKeyModel) Touch(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return m.db.WithContext(ctx).Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')", id).
+		Update("last_used_at", gorm.Expr("NOW()")).Error
+}
@@ -0,0 +1,200 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Projection holds the sparse fieldset and embedded relations requested
+// through the fields= and include= query parameters.
+type Projection struct {
+	Fields  string `form:"fields" binding:"omitempty"`
+	Include string `form:"include" binding:"omitempty"`
+}
+
+// movieFields maps the JSON field names of Input to their columns in the
+// movies table, in the order they are serialized.
+var movieFields = []struct {
+	name   string
+	column string
+}{
+	{"id", "id"},
+	{"title", "title"},
+	{"year", "year"},
+	{"runtime", "runtime"},
+	{"genres", "genres"},
+	{"created_by", "created_by"},
+	{"updated_by", "updated_by"},
+}
+
+// includeLoader fetches one kind of related data for a set of movies, keyed by
+// movie ID.
+type includeLoader func(tx *gorm.DB, tenant string, ids []int64) (map[int64]any, error)
+
+// movieIncludes lists the optional related data that can be embedded with include=.
+var movieIncludes = map[string]includeLoader{
+	"related": relatedMovies,
+}
+
+// UnknownFieldError is returned when fields= or include= names something the
+// movie resource does not have.
+type UnknownFieldError struct {
+	Param   string
+	Name    string
+	Allowed []string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown %s %q, allowed: %s", e.Param, e.Name, strings.Join(e.Allowed, ", "))
+}
+
+// Parse validates the requested fields and includes. A nil fields slice means
+// every field was requested.
+func (p Projection) Parse() (fields []string, includes []string, err error) {
+	allowed := make([]string, len(movieFields))
+	for i, f := range movieFields {
+		allowed[i] = f.name
+	}
+	fields, err = splitList(p.Fields, "field", allowed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowedIncludes := make([]string, 0, len(movieIncludes))
+	for name := range movieIncludes {
+		allowedIncludes = append(allowedIncludes, name)
+	}
+	sort.Strings(allowedIncludes)
+	includes, err = splitList(p.Include, "include", allowedIncludes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fields, includes, nil
+}
+
+func splitList(raw, param string, allowed []string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var out []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(out, name) {
+			continue
+		}
+		if !slices.Contains(allowed, name) {
+			return nil, &UnknownFieldError{Param: param, Name: name, Allowed: allowed}
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// selectColumns returns the columns to load for fields. The id is always
+// selected because includes are keyed on it.
+func selectColumns(fields []string) []string {
+	if fields == nil {
+		return nil
+	}
+	columns := []string{"id"}
+	for _, f := range movieFields {
+		if f.column != "id" && slices.Contains(fields, f.name) {
+			columns = append(columns, f.column)
+		}
+	}
+	return columns
+}
+
+// Project renders a movie as a map holding only the requested fields, in the
+// same order as the full representation.
+func Project(input Input, fields []string) gin.H {
+	values := map[string]any{
+		"id":         input.ID,
+		"title":      input.Title,
+		"year":       input.Year,
+		"runtime":    input.Runtime,
+		"genres":     input.Genres,
+		"created_by": input.CreatedBy,
+		"updated_by": input.UpdatedBy,
+	}
+	out := gin.H{}
+	for _, f := range movieFields {
+		if fields == nil || slices.Contains(fields, f.name) {
+			out[f.name] = values[f.name]
+		}
+	}
+	return out
+}
+
+// FieldNames returns the requested fields in output order, or every field
+// when fields is nil.
+func FieldNames(fields []string) []string {
+	var names []string
+	for _, f := range movieFields {
+		if fields == nil || slices.Contains(fields, f.name) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// LoadIncludes fetches the requested related data for the given movies.
+func (m MovieModel) LoadIncludes(c *gin.Context, includes []string, ids []int64) (map[string]map[int64]any, error) {
+	out := make(map[string]map[int64]any, len(includes))
+	err := scoped(c, m.db, 10*time.Second, func(tx *gorm.DB, tenant string) error {
+		for _, name := range includes {
+			loaded, err := movieIncludes[name](tx, tenant, ids)
+			if err != nil {
+				return fmt.Errorf("include %s: %w", name, err)
+			}
+			out[name] = loaded
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type RelatedMovie struct {
+	ID     int64          `json:"id" xml:"id" yaml:"id"`
+	Title  string         `json:"title" xml:"title" yaml:"title"`
+	Year   int32          `json:"year" xml:"year" yaml:"year"`
+	Genres pq.StringArray `json:"genres" xml:"genres>genre" yaml:"genres"`
+}
+
+// relatedMovies returns up to five other movies sharing a genre with each movie.
+func relatedMovies(tx *gorm.DB, tenant string, ids []int64) (map[int64]any, error) {
+	var rows []struct {
+		SourceID int64
+		RelatedMovie
+	}
+	err := tx.Raw(`
+	SELECT m.id AS source_id, r.id, r.title, r.year, r.genres FROM movies m
+	CROSS JOIN LATERAL (
+		SELECT id, title, year, genres FROM movies r
+		WHERE r.tenant_id = m.tenant_id AND r.id <> m.id AND r.genres && m.genres
+		ORDER BY r.year DESC, r.id LIMIT 5
+	) r
+	WHERE m.tenant_id = ? AND m.id IN ?`, tenant, ids).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[int64]any, len(ids))
+	for _, id := range ids {
+		out[id] = []RelatedMovie{}
+	}
+	for _, row := range rows {
+		out[row.SourceID] = append(out[row.SourceID].([]RelatedMovie), row.RelatedMovie)
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// invitationScheme starts every invitation code, like apiKeyScheme does for keys.
+const invitationScheme = "gli_"
+
+type Invitation struct {
+	ID        int64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	Hint      string         `json:"hint" gorm:"not null"`
+	TenantID  string         `json:"tenant_id" gorm:"not null"`
+	CodeHash  []byte         `json:"-" gorm:"not null"`
+	Roles     pq.StringArray `json:"roles" gorm:"type:text[]"`
+	MaxUses   int            `json:"max_uses" gorm:"not null"`
+	Uses      int            `json:"uses" gorm:"not null"`
+	CreatedBy string         `json:"created_by"`
+	CreatedAt time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	ExpiresAt time.Time      `json:"expires_at" gorm:"not null"`
+	RevokedAt *time.Time     `json:"revoked_at"`
+}
+
+type InvitationInput struct {
+	// Tenant the invited users join, the admin's own when empty
+	Tenant    string     `json:"tenant" binding:"omitempty,min=2,max=63" pattern:"^[a-z0-9][a-z0-9-]+$"`
+	Roles     []string   `json:"roles" binding:"required,min=1,dive,oneof=reader writer admin"`
+	MaxUses   int        `json:"max_uses" binding:"omitempty,gte=1,lte=1000"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// NewInvitationCode returns a fresh code with the hint shown in listings and
+// the hash that is stored instead of the code.
+func NewInvitationCode() (code, hint string, hash []byte, err error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", nil, err
+	}
+	code = invitationScheme + base64.RawURLEncoding.EncodeToString(random)
+	return code, code[:len(invitationScheme)+6], HashInvitationCode(code), nil
+}
+
+func HashInvitationCode(code string) []byte {
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
+
+// Invitations are created by admins for a tenant and redeemed before the
+// user exists, so they are not subject to row-level security; listing and
+// revoking filter by tenant instead.
+type InvitationModel struct {
+	db *gorm.DB
+}
+
+func (m InvitationModel) Insert(c *gin.Context, invitation *Invitation) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	err := m.db.WithContext(ctx).Create(invitation).Error
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+// List returns the invitations of tenant, of every tenant when it is empty.
+func (m InvitationModel) List(c *gin.Context, tenant string) ([]Invitation, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	query := m.db.WithContext(ctx).Order("id")
+	if tenant != "" {
+		query = query.Where("tenant_id = ?", tenant)
+	}
+	var invitations []Invitation
+	if err := query.Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// Revoke stops the code from being redeemed. Revoking twice is not an error.
+// Unless tenant is empty an invitation of another tenant is not found.
+func (m InvitationModel) Revoke(c *gin.Context, id int64, tenant string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	var invitation Invitation
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		if tenant != "" {
+			query = query.Where("tenant_id = ?", tenant)
+		}
+		if err := query.First(&invitation, id).Error; err != nil {
+			return err
+		}
+		if invitation.RevokedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		invitation.RevokedAt = &now
+		return tx.Model(&invitation).Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// Redeem uses up one use of the code in a single statement, so concurrent
+// registrations cannot exceed the use limit. Unknown, revoked, expired and
+// used up codes all fail with ErrInvitationInvalid.
+func (m InvitationModel) Redeem(ctx context.Context, code string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var invitation Invitation
+	result := m.db.WithContext(ctx).Model(&invitation).Clauses(clause.Returning{}).
+		Where("code_hash = ? AND uses < max_uses AND revoked_at IS NULL AND expires_at > NOW()", HashInvitationCode(code)).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvitationInvalid
+	}
+	return &invitation, nil
+}
+
+// Release gives back a use taken by Redeem when the registration failed.
+func (m InvitationModel) Release(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return m.db.WithContext(ctx).Model(&Invitation{}).Where("id = ? AND uses > 0", id).
+		Update("uses", gorm.Expr("uses - 1")).Error
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginAttempt counts the recent failed logins of a username or client IP.
+type LoginAttempt struct {
+	Key         string `gorm:"primaryKey"`
+	Failures    int
+	LastFailure time.Time
+	LockedUntil *time.Time
+}
+
+// Locked reports whether the key is locked out at now.
+func (a *LoginAttempt) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+// LoginAttemptModel is shared by every API instance, so that spreading
+// guesses over instances does not multiply the allowed attempts.
+type LoginAttemptModel struct {
+	db *gorm.DB
+}
+
+// Get returns the attempts recorded for the keys within window, keys
+// without recent failures are left out.
+func (m LoginAttemptModel) Get(ctx context.Context, window time.Duration, keys ...string) ([]LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var attempts []LoginAttempt
+	err := m.db.WithContext(ctx).
+		Where("key IN ? AND (last_failure > NOW() - make_interval(secs => ?) OR locked_until > NOW())", keys, window.Seconds()).
+		Find(&attempts).Error
+	return attempts, err
+}
+
+// Fail records a failed login. Failures older than window no longer count,
+// reaching limit locks the key for lockout.
+func (m LoginAttemptModel) Fail(ctx context.Context, key string, window time.Duration, limit int, lockout time.Duration) (*LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var attempt LoginAttempt
+	err := m.db.WithContext(ctx).Raw(`
+	INSERT INTO login_attempts AS a (key, failures, last_failure, locked_until)
+	VALUES (@key, 1, NOW(), CASE WHEN @limit <= 1 THEN NOW() + make_interval(secs => @lockout) END)
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN a.last_failure > NOW() - make_interval(secs => @window) THEN a.failures + 1 ELSE 1 END,
+		last_failure = NOW(),
+		locked_until = CASE
+			WHEN (CASE WHEN a.last_failure > NOW() - make_interval(secs => @window) THEN a.failures + 1 ELSE 1 END) >= @limit
+			THEN NOW() + make_interval(secs => @lockout)
+			ELSE a.locked_until END
+	RETURNING key, failures, last_failure, locked_until`,
+		map[string]any{"key": key, "window": window.Seconds(), "limit": limit, "lockout": lockout.Seconds()}).
+		Scan(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// Reset forgets the failures of the keys and lifts their lockout.
+func (m LoginAttemptModel) Reset(ctx context.Context, keys ...string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result := m.db.WithContext(ctx).Where("key IN ?", keys).Delete(&LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteStale removes keys whose failures are older than window and that are not locked.
+func (m LoginAttemptModel) DeleteStale(ctx context.Context, window time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result := m.db.WithContext(ctx).
+		Where("last_failure <= NOW() - make_interval(secs => ?) AND (locked_until IS NULL OR locked_until <= NOW())", window.Seconds()).
+		Delete(&LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
@@ -15,13 +15,27 @@ var (
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	Movies MovieModel
+	Movies       MovieModel
+	Tenants      TenantModel
+	APIKeys      APIKeyModel
+	Revoked      RevokedTokenModel
+	Invitations  InvitationModel
+	RoleRequests RoleRequestModel
+	Passwords    PasswordHistoryModel
+	Logins       LoginAttemptModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
 func NewModels(db *gorm.DB) Models {
 	return Models{
-		Movies: MovieModel{db: db},
+		Movies:       MovieModel{db: db},
+		Tenants:      TenantModel{db: db},
+		APIKeys:      APIKeyModel{db: db},
+		Revoked:      RevokedTokenModel{db: db},
+		Invitations:  InvitationModel{db: db},
+		RoleRequests: RoleRequestModel{db: db},
+		Passwords:    PasswordHistoryModel{db: db},
+		Logins:       LoginAttemptModel{db: db},
 	}
 }
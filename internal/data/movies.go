@@ -1,9 +1,9 @@
 package data
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,11 +12,11 @@ import (
 )
 
 type Metadata struct {
-	CurrentPage  int   `json:"current_page"`
-	PageSize     int   `json:"page_size"`
-	FirstPage    int   `json:"first_page"`
-	LastPage     int   `json:"last_page"`
-	TotalRecords int64 `json:"total_records"`
+	CurrentPage  int   `json:"current_page" xml:"current_page" yaml:"current_page"`
+	PageSize     int   `json:"page_size" xml:"page_size" yaml:"page_size"`
+	FirstPage    int   `json:"first_page" xml:"first_page" yaml:"first_page"`
+	LastPage     int   `json:"last_page" xml:"last_page" yaml:"last_page"`
+	TotalRecords int64 `json:"total_records" xml:"total_records" yaml:"total_records"`
 }
 
 type Filters struct {
@@ -26,33 +26,41 @@ type Filters struct {
 	Order    string `form:"order" binding:"alpha,oneof=asc desc"`
 	Pretty   bool   `form:"pretty" binding:"boolean"`
 	Title    string `form:"title" binding:"omitempty"`
+	Owner    string `form:"owner" binding:"omitempty,oneof=me"`
+	Projection
 }
 
 type Update struct {
-	Title   string         `json:"title" binding:"omitempty"`
-	Year    int32          `json:"year" binding:"omitempty,gte=1947"`
-	Runtime int32          `json:"runtime" binding:"omitempty"`
-	Genres  pq.StringArray `json:"genres" binding:"omitempty"`
+	Title   string         `json:"title" xml:"title" yaml:"title" binding:"omitempty"`
+	Year    int32          `json:"year" xml:"year" yaml:"year" binding:"omitempty,gte=1947"`
+	Runtime int32          `json:"runtime" xml:"runtime" yaml:"runtime" binding:"omitempty"`
+	Genres  pq.StringArray `json:"genres" xml:"genres>genre" yaml:"genres" binding:"omitempty"`
 }
 
 type Input struct {
-	ID        int64     `json:"id" binding:"required"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title" binding:"required"`
-	Year      int32     `json:"year" binding:"required,gte=1947"`
-	Runtime   int32     `json:"runtime" binding:"required"`
-	Genres    []string  `json:"genres" binding:"required"`
-	Version   int32     `json:"-"`
+	ID        int64     `json:"id" xml:"id" yaml:"id" binding:"required"`
+	CreatedAt time.Time `json:"-" xml:"-" yaml:"-"`
+	Title     string    `json:"title" xml:"title" yaml:"title" binding:"required"`
+	Year      int32     `json:"year" xml:"year" yaml:"year" binding:"required,gte=1947"`
+	Runtime   int32     `json:"runtime" xml:"runtime" yaml:"runtime" binding:"required"`
+	Genres    []string  `json:"genres" xml:"genres>genre" yaml:"genres" binding:"required"`
+	Version   int32     `json:"-" xml:"-" yaml:"-"`
+	CreatedBy string    `json:"created_by,omitempty" xml:"created_by,omitempty" yaml:"created_by,omitempty" binding:"-"`
+	UpdatedBy string    `json:"updated_by,omitempty" xml:"updated_by,omitempty" yaml:"updated_by,omitempty" binding:"-"`
 }
 
 type Movies struct {
 	ID        int64          `gorm:"primaryKey;autoIncrement"`
 	CreatedAt time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
 	Title     string         `gorm:"not null"`
 	Year      int32          `gorm:"not null"`
 	Runtime   int32          `gorm:"not null"`
 	Genres    pq.StringArray `gorm:"type:text[]"`
 	Version   int32          `gorm:"default:1"`
+	TenantID  string         `gorm:"not null"`
+	CreatedBy string         `gorm:"not null;default:''"`
+	UpdatedBy string         `gorm:"not null;default:''"`
 }
 
 type MovieModel struct {
@@ -60,39 +68,40 @@ type MovieModel struct {
 }
 
 // Add a placeholder method for inserting a new record in the movies table.
+// The movie is always created in the caller's tenant.
 func (m MovieModel) Insert(c *gin.Context, movie *Movies) error {
-
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
-	defer cancel()
-
-	if err := m.db.WithContext(ctx).Create(&movie).Error; err != nil {
-		return err
-	}
-	return nil
+	return scoped(c, m.db, 10*time.Second, func(tx *gorm.DB, tenant string) error {
+		movie.TenantID = tenant
+		movie.CreatedBy = c.GetString(SubjectContextKey)
+		movie.UpdatedBy = movie.CreatedBy
+		return tx.Create(&movie).Error
+	})
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
-func (m MovieModel) Get(c *gin.Context, id int64) (*Movies, error) {
-
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
-	defer cancel()
-
+// Only the columns backing fields are loaded, a nil fields loads them all.
+func (m MovieModel) Get(c *gin.Context, id int64, fields []string) (*Movies, error) {
 	var movie Movies
-	err := m.db.WithContext(ctx).First(&movie, id).Error // Fetch movie with ID = 1
+	err := scoped(c, m.db, 10*time.Second, func(tx *gorm.DB, tenant string) error {
+		if columns := selectColumns(fields); columns != nil {
+			tx = tx.Select(columns)
+		}
+		return tx.First(&movie, id).Error // Fetch movie with ID = 1
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &movie, nil
 }
 
-func (m *MovieModel) UpdateMovieInTransaction(c *gin.Context, id int64, update Update) (*Movies, error) {
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
-	defer cancel()
-
+// UpdateMovieInTransaction applies update to the movie. A non-empty owner
+// restricts the update to movies created by that subject, others fail with
+// ErrNotOwner.
+func (m *MovieModel) UpdateMovieInTransaction(c *gin.Context, id int64, update Update, owner string) (*Movies, error) {
 	var updatedMovie Movies
 
 	// Start a transaction
-	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := scoped(c, m.db, 10*time.Second, func(tx *gorm.DB, tenant string) error {
 		var movie Movies
 
 		// Retrieve movie record inside the transaction
@@ -103,6 +112,10 @@ func (m *MovieModel) UpdateMovieInTransaction(c *gin.Context, id int64, update U
 			return fmt.Errorf("db_error: %w", err) // Wrap other DB errors
 		}
 
+		if owner != "" && movie.CreatedBy != owner {
+			return ErrNotOwner
+		}
+
 		// Apply updates
 		if update.Title != "" {
 			movie.Title = update.Title
@@ -129,6 +142,7 @@ func (m *MovieModel) UpdateMovieInTransaction(c *gin.Context, id int64, update U
 		// Optimistic locking: Ensure the version matches before updating
 		prevVersion := movie.Version
 		movie.Version++
+		movie.UpdatedBy = c.GetString(SubjectContextKey)
 
 		result := tx.Model(&movie).
 			Where("id = ? AND version = ?", movie.ID, prevVersion).
@@ -155,49 +169,83 @@ func (m *MovieModel) UpdateMovieInTransaction(c *gin.Context, id int64, update U
 }
 
 // Add a placeholder method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(c *gin.Context, id int64) error {
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
-	defer cancel()
+// A non-empty owner restricts the delete to movies created by that subject.
+func (m MovieModel) Delete(c *gin.Context, id int64, owner string) error {
+	return scoped(c, m.db, 100*time.Second, func(tx *gorm.DB, tenant string) error {
+		if owner != "" {
+			var movie Movies
+			if err := tx.Select("id", "created_by").First(&movie, id).Error; err != nil {
+				return err
+			}
+			if movie.CreatedBy != owner {
+				return ErrNotOwner
+			}
+		}
 
-	// result := m.db.Debug().WithContext(ctx).Where("ID = ?", id).Delete(&Movies{}) // Prints Query
-	result := m.db.WithContext(ctx).Where("ID = ?", id).Delete(&Movies{})
+		// result := tx.Debug().Where("ID = ?", id).Delete(&Movies{}) // Prints Query
+		result := tx.Where("ID = ?", id).Delete(&Movies{})
 
-	if result.Error != nil {
-		return result.Error
-	}
+		if result.Error != nil {
+			return result.Error
+		}
 
-	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound // Custom error if no rows were deleted
-	}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound // Custom error if no rows were deleted
+		}
 
-	return nil
+		return nil
+	})
 }
 
-func (m MovieModel) List(c *gin.Context, filter *Filters) (*[]Movies, error) {
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
-	defer cancel()
-
+// List returns a page of the tenant's movies along with the tenant's total
+// number of movies.
+func (m MovieModel) List(c *gin.Context, filter *Filters, fields []string) (*[]Movies, int64, error) {
 	offset := (filter.Page - 1) * filter.PageSize
 
 	var movies []Movies
-	err := m.db.WithContext(ctx).Order(filter.Sort + " " + filter.Order).Limit(filter.PageSize).Offset(offset).Find(&movies).Error
+	var totalRecords int64
+	err := scoped(c, m.db, 100*time.Second, func(tx *gorm.DB, tenant string) error {
+		if filter.Owner == "me" {
+			tx = tx.Where("created_by = ?", c.GetString(SubjectContextKey)).Session(&gorm.Session{})
+		}
+		if err := tx.Model(&Movies{}).Count(&totalRecords).Error; err != nil {
+			return err
+		}
+
+		query := tx
+		if columns := selectColumns(fields); columns != nil {
+			query = query.Select(columns)
+		}
+		return query.Order(filter.Sort + " " + filter.Order).Limit(filter.PageSize).Offset(offset).Find(&movies).Error
+	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return &movies, nil
+	return &movies, totalRecords, nil
 }
 
-func (m MovieModel) Search(c *gin.Context, filter *Filters) (*[]Movies, int64, error) {
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
-	defer cancel()
+func (m MovieModel) Search(c *gin.Context, filter *Filters, fields []string) (*[]Movies, int64, error) {
+	// The column names come from the movieFields allow-list, never from the request
+	columns := "*"
+	if selected := selectColumns(fields); selected != nil {
+		columns = strings.Join(selected, ", ")
+	}
 
 	var movies []Movies
 	var totalRecords int64
-	err := m.db.WithContext(ctx).Raw(`
-	SELECT * FROM movies WHERE to_tsvector('english', title) @@ plainto_tsquery(?) 
-	ORDER BY ts_rank_cd(to_tsvector('english', title), plainto_tsquery(?)) DESC`, filter.Title, filter.Title).
-		Scan(&movies).Error
+	// owner=me is matched with an empty owner meaning any creator
+	owner := ""
+	if filter.Owner == "me" {
+		owner = c.GetString(SubjectContextKey)
+	}
+
+	err := scoped(c, m.db, 100*time.Second, func(tx *gorm.DB, tenant string) error {
+		return tx.Raw(`
+	SELECT `+columns+` FROM movies WHERE tenant_id = ? AND (? = '' OR created_by = ?) AND to_tsvector('english', title) @@ plainto_tsquery(?) 
+	ORDER BY ts_rank_cd(to_tsvector('english', title), plainto_tsquery(?)) DESC`, tenant, owner, owner, filter.Title, filter.Title).
+			Scan(&movies).Error
+	})
 	if err != nil {
 		return nil, 0, err
 	}
@@ -208,3 +256,43 @@ func (m MovieModel) Search(c *gin.Context, filter *Filters) (*[]Movies, int64, e
 
 	return &movies, totalRecords, nil
 }
+
+// Recent returns the most recently added movies, newest first. When genres is
+// not empty only movies in at least one of them are returned.
+func (m MovieModel) Recent(c *gin.Context, genres []string, limit int) ([]Movies, error) {
+	var movies []Movies
+	err := scoped(c, m.db, 10*time.Second, func(tx *gorm.DB, tenant string) error {
+		query := tx.Order("created_at DESC, id DESC").Limit(limit)
+		if len(genres) > 0 {
+			query = query.Where("genres && ?", pq.StringArray(genres))
+		}
+		return query.Find(&movies).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// TransferOwnership hands the movie over to a new owner within the caller's tenant.
+func (m MovieModel) TransferOwnership(c *gin.Context, id int64, owner string) (*Movies, error) {
+	var movie Movies
+	err := scoped(c, m.db, 10*time.Second, func(tx *gorm.DB, tenant string) error {
+		result := tx.Model(&Movies{}).Where("id = ?", id).Updates(map[string]any{
+			"created_by": owner,
+			"updated_by": c.GetString(SubjectContextKey),
+			"version":    gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.First(&movie, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &movie, nil
+}
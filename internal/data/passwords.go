@@ -0,0 +1,76 @@
+package data
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256.
+const pbkdf2Iterations = 600000
+
+// PasswordHash is a previous password of a user. The identity provider keeps
+// the live password, these hashes are only compared against new ones.
+type PasswordHash struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement"`
+	UserID     string    `gorm:"not null"`
+	Salt       []byte    `gorm:"not null"`
+	Iterations int       `gorm:"not null"`
+	Hash       []byte    `gorm:"not null"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+func (PasswordHash) TableName() string {
+	return "password_history"
+}
+
+func HashPassword(userID, password string) (*PasswordHash, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordHash{UserID: userID, Salt: salt, Iterations: pbkdf2Iterations, Hash: hash}, nil
+}
+
+// Matches compares password against the hash in constant time.
+func (h *PasswordHash) Matches(password string) bool {
+	hash, err := pbkdf2.Key(sha256.New, password, h.Salt, h.Iterations, len(h.Hash))
+	return err == nil && subtle.ConstantTimeCompare(hash, h.Hash) == 1
+}
+
+type PasswordHistoryModel struct {
+	db *gorm.DB
+}
+
+// Recent returns the last n passwords of the user, newest first.
+func (m PasswordHistoryModel) Recent(ctx context.Context, userID string, n int) ([]PasswordHash, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var hashes []PasswordHash
+	err := m.db.WithContext(ctx).Where("user_id = ?", userID).Order("id DESC").Limit(n).Find(&hashes).Error
+	return hashes, err
+}
+
+// Add records a new password and forgets all but the last keep ones.
+func (m PasswordHistoryModel) Add(ctx context.Context, hash *PasswordHash, keep int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(hash).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?)`, hash.UserID, hash.UserID, keep).Error
+	})
+}
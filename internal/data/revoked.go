@@ -0,0 +1,50 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokedToken is an access token that must be rejected until it expires.
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey"`
+	Subject   string    `gorm:"not null;default:''"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// RevokedTokenModel is the deny-list shared by every API instance.
+type RevokedTokenModel struct {
+	db *gorm.DB
+}
+
+// Insert adds a token to the deny-list, revoking a token twice is not an error.
+func (m RevokedTokenModel) Insert(c *gin.Context, jti, subject string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	token := RevokedToken{JTI: jti, Subject: subject, ExpiresAt: expiresAt}
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&token).Error
+}
+
+func (m RevokedTokenModel) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var count int64
+	err := m.db.WithContext(ctx).Model(&RevokedToken{}).Where("jti = ? AND expires_at > NOW()", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteExpired removes entries of tokens that have expired by now.
+func (m RevokedTokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result := m.db.WithContext(ctx).Where("expires_at <= NOW()").Delete(&RevokedToken{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestDenied   = "denied"
+	RoleRequestExpired  = "expired"
+)
+
+type RoleRequest struct {
+	ID                int64      `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID            string     `json:"user_id" gorm:"not null"`
+	TenantID          string     `json:"tenant_id" gorm:"not null"`
+	Username          string     `json:"username"`
+	Role              string     `json:"role" gorm:"not null"`
+	Justification     string     `json:"justification" gorm:"not null"`
+	RequestedDuration string     `json:"requested_duration,omitempty"`
+	Status            string     `json:"status" gorm:"not null;default:pending"`
+	DecidedBy         string     `json:"decided_by,omitempty"`
+	DecidedAt         *time.Time `json:"decided_at,omitempty"`
+	Note              string     `json:"note,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+type RoleRequestInput struct {
+	Role          string `json:"role" binding:"required,oneof=reader writer admin"`
+	Justification string `json:"justification" binding:"required,min=10,max=1000"`
+	// Duration is a Go duration such as "72h", empty asks for a permanent grant
+	Duration string `json:"duration" binding:"omitempty"`
+}
+
+// RoleDecision is what an admin sends to approve or deny a request.
+type RoleDecision struct {
+	Note string `json:"note" binding:"omitempty,max=1000"`
+	// Duration overrides the requested duration on approval, "0s" grants the role permanently
+	Duration string `json:"duration" binding:"omitempty"`
+}
+
+// Role requests are kept per tenant, the roles they grant live in the identity provider.
+type RoleRequestModel struct {
+	db *gorm.DB
+}
+
+// Insert files a request, a second pending request for the same role fails
+// with ErrDuplicateKey.
+func (m RoleRequestModel) Insert(c *gin.Context, request *RoleRequest) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	err := m.db.WithContext(ctx).Create(request).Error
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+// Get loads a request, limited to tenant unless it is empty. A request of
+// another tenant is reported as not found.
+func (m RoleRequestModel) Get(c *gin.Context, id int64, tenant string) (*RoleRequest, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	query := m.db.WithContext(ctx)
+	if tenant != "" {
+		query = query.Where("tenant_id = ?", tenant)
+	}
+	var request RoleRequest
+	if err := query.First(&request, id).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// List returns the requests of a user, or of everyone when userID is empty,
+// optionally only those of tenant and in status. The newest come first.
+func (m RoleRequestModel) List(c *gin.Context, userID, tenant, status string) ([]RoleRequest, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	query := m.db.WithContext(ctx).Order("id DESC")
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if tenant != "" {
+		query = query.Where("tenant_id = ?", tenant)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	requests := []RoleRequest{}
+	if err := query.Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// Decide moves a pending request to status. Only one admin can decide a
+// request, the others get ErrRequestDecided.
+func (m RoleRequestModel) Decide(c *gin.Context, id int64, status, decidedBy, note string, expiresAt *time.Time) (*RoleRequest, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	var request RoleRequest
+	result := m.db.WithContext(ctx).Model(&request).Clauses(clause.Returning{}).
+		Where("id = ? AND status = ?", id, RoleRequestPending).
+		Updates(map[string]any{
+			"status":     status,
+			"decided_by": decidedBy,
+			"decided_at": gorm.Expr("NOW()"),
+			"note":       note,
+			"expires_at": expiresAt,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := m.db.WithContext(ctx).Select("id").First(&RoleRequest{}, id).Error; err != nil {
+			return nil, err
+		}
+		return nil, ErrRequestDecided
+	}
+	return &request, nil
+}
+
+// Reopen puts an approved request back to pending, for when the grant failed.
+func (m RoleRequestModel) Reopen(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return m.db.WithContext(ctx).Model(&RoleRequest{}).Where("id = ? AND status = ?", id, RoleRequestApproved).
+		Updates(map[string]any{"status": RoleRequestPending, "decided_by": "", "decided_at": nil, "expires_at": nil}).Error
+}
+
+// DueForExpiry returns the approved grants whose time is up.
+func (m RoleRequestModel) DueForExpiry(ctx context.Context) ([]RoleRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var requests []RoleRequest
+	err := m.db.WithContext(ctx).
+		Where("status = ? AND expires_at <= NOW()", RoleRequestApproved).
+		Order("expires_at").Limit(100).Find(&requests).Error
+	return requests, err
+}
+
+// MarkExpired records that the grant was taken back. A request that is no
+// longer approved, because another instance expired it first, is left alone.
+func (m RoleRequestModel) MarkExpired(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return m.db.WithContext(ctx).Model(&RoleRequest{}).Where("id = ? AND status = ?", id, RoleRequestApproved).
+		Update("status", RoleRequestExpired).Error
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// TenantContextKey is the gin context key AuthMiddleware stores the
+// caller's tenant under. Every MovieModel method reads it from there.
+const TenantContextKey = "tenant"
+
+// SubjectContextKey holds the JWT subject of the caller, recorded as the
+// creator or last editor of the rows they write.
+const SubjectContextKey = "subject"
+
+var (
+	ErrMissingTenant     = errors.New("no tenant in request context")
+	ErrDuplicateKey      = errors.New("duplicate key")
+	ErrNotOwner          = errors.New("record is owned by another user")
+	ErrKeyInactive       = errors.New("api key is revoked or expired")
+	ErrInvitationInvalid = errors.New("invitation code is unknown, used up, revoked or expired")
+	ErrRequestDecided    = errors.New("role request was already decided")
+)
+
+type Tenant struct {
+	ID             string         `json:"id" gorm:"primaryKey"`
+	Name           string         `json:"name" gorm:"not null"`
+	CreatedAt      time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	RateLimitRPS   *float64       `json:"rate_limit_rps"`
+	RateLimitBurst *int           `json:"rate_limit_burst"`
+	AllowedGenres  pq.StringArray `json:"allowed_genres" gorm:"type:text[]"`
+	PublicFeeds    bool           `json:"public_feeds" gorm:"not null;default:false"`
+	Version        int32          `json:"version" gorm:"default:1"`
+}
+
+// TenantSettings are the per tenant overrides. A nil rate limit falls back to
+// the server wide limiter settings, an empty genre list allows every genre.
+// PublicFeeds lets anyone read the tenant's feeds without a token.
+type TenantSettings struct {
+	RateLimitRPS   *float64 `json:"rate_limit_rps" binding:"omitempty,gt=0"`
+	RateLimitBurst *int     `json:"rate_limit_burst" binding:"omitempty,gte=1"`
+	AllowedGenres  []string `json:"allowed_genres" binding:"omitempty,dive,min=1"`
+	PublicFeeds    bool     `json:"public_feeds"`
+}
+
+type TenantInput struct {
+	ID   string `json:"id" binding:"required,min=2,max=63" pattern:"^[a-z0-9][a-z0-9-]+$"`
+	Name string `json:"name" binding:"required,min=2,max=100"`
+	TenantSettings
+}
+
+type TenantModel struct {
+	db *gorm.DB
+}
+
+func (m TenantModel) Insert(c *gin.Context, tenant *Tenant) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	err := m.db.WithContext(ctx).Create(tenant).Error
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (m TenantModel) Get(ctx context.Context, id string) (*Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var tenant Tenant
+	if err := m.db.WithContext(ctx).First(&tenant, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+func (m TenantModel) List(c *gin.Context) ([]Tenant, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	var tenants []Tenant
+	if err := m.db.WithContext(ctx).Order("id").Find(&tenants).Error; err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+func (m TenantModel) UpdateSettings(c *gin.Context, id string, settings TenantSettings) (*Tenant, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	result := m.db.WithContext(ctx).Model(&Tenant{}).Where("id = ?", id).Updates(map[string]any{
+		"rate_limit_rps":   settings.RateLimitRPS,
+		"rate_limit_burst": settings.RateLimitBurst,
+		"allowed_genres":   pq.StringArray(settings.AllowedGenres),
+		"public_feeds":     settings.PublicFeeds,
+		"version":          gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return m.Get(ctx, id)
+}
+
+// scoped runs fn in a transaction bound to the caller's tenant. The tenant is
+// handed to the row-level security policies through app.tenant_id and also
+// added as a condition on tx, so a missing policy cannot leak rows either.
+func scoped(c *gin.Context, db *gorm.DB, timeout time.Duration, fn func(tx *gorm.DB, tenant string) error) error {
+	tenant := c.GetString(TenantContextKey)
+	if tenant == "" {
+		return ErrMissingTenant
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT set_config('app.tenant_id', ?, true)", tenant).Error; err != nil {
+			return err
+		}
+		// A new session so the condition is shared, not accumulated, by the queries in fn
+		return fn(tx.Where("tenant_id = ?", tenant).Session(&gorm.Session{}), tenant)
+	})
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
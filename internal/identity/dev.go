@@ -0,0 +1,442 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// DevUser is an account of the development issuer.
+type DevUser struct {
+	Username  string   `yaml:"username"`
+	Password  string   `yaml:"password"`
+	Email     string   `yaml:"email"`
+	FirstName string   `yaml:"first_name"`
+	LastName  string   `yaml:"last_name"`
+	Tenant    string   `yaml:"tenant"`
+	Roles     []string `yaml:"roles"`
+	Scopes    []string `yaml:"scopes"`
+}
+
+type DevConfig struct {
+	Issuer   string
+	ClientID string
+	// TenantClaim is the claim the API reads the tenant from.
+	TenantClaim string
+	TokenTTL    time.Duration
+	RefreshTTL  time.Duration
+	Users       []DevUser
+}
+
+// Dev is a minimal OpenID Connect issuer for local development. It signs
+// tokens shaped like Keycloak's with an RSA key generated at startup, so the
+// API validates them exactly like tokens of a real provider.
+type Dev struct {
+	cfg DevConfig
+	key *rsa.PrivateKey
+	kid string
+
+	mu       sync.Mutex
+	users    map[string]*DevUser
+	sessions map[string]devSession
+	// unverified holds the users whose email changed since startup
+	unverified map[string]bool
+	disabled   map[string]bool
+}
+
+type devSession struct {
+	username string
+	expires  time.Time
+}
+
+func NewDev(cfg DevConfig) (*Dev, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+
+	d := &Dev{
+		cfg:        cfg,
+		key:        key,
+		kid:        hex.EncodeToString(sum[:8]),
+		users:      make(map[string]*DevUser),
+		sessions:   make(map[string]devSession),
+		unverified: make(map[string]bool),
+		disabled:   make(map[string]bool),
+	}
+	for i := range cfg.Users {
+		user := cfg.Users[i]
+		if user.Username == "" || user.Password == "" {
+			return nil, fmt.Errorf("dev user %d needs a username and password", i)
+		}
+		d.users[user.Username] = &user
+	}
+	return d, nil
+}
+
+func (d *Dev) Discovery() Discovery {
+	return Discovery{
+		Issuer:             d.cfg.Issuer,
+		TokenEndpoint:      d.cfg.Issuer + "/token",
+		JWKSURI:            d.cfg.Issuer + "/jwks",
+		RevocationEndpoint: d.cfg.Issuer + "/revoke",
+	}
+}
+
+// KeySet is the JWKS with the public half of the signing key.
+func (d *Dev) KeySet() (jwk.Set, error) {
+	key, err := jwk.New(&d.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range map[string]any{jwk.KeyIDKey: d.kid, jwk.AlgorithmKey: "RS256", jwk.KeyUsageKey: "sig"} {
+		if err := key.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	set := jwk.NewSet()
+	set.Add(key)
+	return set, nil
+}
+
+func (d *Dev) Login(ctx context.Context, username, password string) (*TokenSet, error) {
+	d.mu.Lock()
+	user, ok := d.users[username]
+	d.mu.Unlock()
+	if !ok || user.Password != password || d.isDisabled(username) {
+		return nil, ErrInvalidCredentials
+	}
+	return d.issue(user)
+}
+
+func (d *Dev) Refresh(ctx context.Context, refreshToken string) (*TokenSet, error) {
+	d.mu.Lock()
+	session, ok := d.sessions[refreshToken]
+	delete(d.sessions, refreshToken)
+	user := d.users[session.username]
+	d.mu.Unlock()
+	if !ok || user == nil || time.Now().After(session.expires) || d.isDisabled(user.Username) {
+		return nil, ErrInvalidToken
+	}
+	return d.issue(user)
+}
+
+func (d *Dev) Logout(ctx context.Context, refreshToken string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.sessions[refreshToken]; !ok {
+		return ErrInvalidToken
+	}
+	delete(d.sessions, refreshToken)
+	return nil
+}
+
+func (d *Dev) FindUser(ctx context.Context, username string) (*User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user, ok := d.users[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return d.user(user), nil
+}
+
+func (d *Dev) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, user := range d.users {
+		if strings.EqualFold(user.Email, email) {
+			return d.user(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// CreateUser registers a user without roles in the default tenant, it is
+// forgotten on restart.
+func (d *Dev) CreateUser(ctx context.Context, user NewUser) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.users[user.Username]; exists {
+		return "", ErrUserExists
+	}
+	d.users[user.Username] = &DevUser{
+		Username:  user.Username,
+		Password:  user.Password,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Tenant:    user.Tenant,
+	}
+	d.unverified[user.Username] = true
+	return devSubject(user.Username), nil
+}
+
+func (d *Dev) SendPasswordReset(ctx context.Context, userID string) error {
+	return ErrNotSupported
+}
+
+func (d *Dev) GetUser(ctx context.Context, userID string) (*User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return d.user(user), nil
+}
+
+func (d *Dev) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if update.FirstName != nil {
+		user.FirstName = *update.FirstName
+	}
+	if update.LastName != nil {
+		user.LastName = *update.LastName
+	}
+	if update.Email != nil && !strings.EqualFold(*update.Email, user.Email) {
+		user.Email = *update.Email
+		d.unverified[user.Username] = true
+	}
+	return d.user(user), nil
+}
+
+// SendVerifyEmail has no mail to send, the address counts as verified right away.
+func (d *Dev) SendVerifyEmail(ctx context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	delete(d.unverified, user.Username)
+	return nil
+}
+
+func (d *Dev) CheckPassword(ctx context.Context, username, password string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user, ok := d.users[username]
+	if !ok || user.Password != password {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+func (d *Dev) SetPassword(ctx context.Context, userID, password string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	user.Password = password
+	return nil
+}
+
+func (d *Dev) ListUsers(ctx context.Context, query UserQuery) ([]User, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	search := strings.ToLower(query.Search)
+	var matches []User
+	for _, user := range d.users {
+		haystack := strings.ToLower(strings.Join([]string{user.Username, user.Email, user.FirstName, user.LastName}, " "))
+		if strings.Contains(haystack, search) && (query.Tenant == "" || user.tenant() == query.Tenant) {
+			matches = append(matches, *d.user(user))
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Username < matches[j].Username })
+
+	total := len(matches)
+	first := min(query.First, total)
+	last := min(first+query.Max, total)
+	return matches[first:last], total, nil
+}
+
+func (d *Dev) SetEnabled(ctx context.Context, userID string, enabled bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if enabled {
+		delete(d.disabled, user.Username)
+	} else {
+		d.disabled[user.Username] = true
+	}
+	return nil
+}
+
+func (d *Dev) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return slices.Clone(user.Roles), nil
+}
+
+func (d *Dev) AddRole(ctx context.Context, userID, role string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if !slices.Contains(user.Roles, role) {
+		user.Roles = append(slices.Clone(user.Roles), role)
+	}
+	return nil
+}
+
+func (d *Dev) RemoveRole(ctx context.Context, userID, role string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	user.Roles = slices.DeleteFunc(slices.Clone(user.Roles), func(r string) bool { return r == role })
+	return nil
+}
+
+func (d *Dev) LogoutAll(ctx context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.byID(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	for token, session := range d.sessions {
+		if session.username == user.Username {
+			delete(d.sessions, token)
+		}
+	}
+	return nil
+}
+
+// RequireActions is not supported, the dev issuer has no login pages.
+func (d *Dev) RequireActions(ctx context.Context, userID string, actions []string) error {
+	return ErrNotSupported
+}
+
+func (d *Dev) byID(userID string) *DevUser {
+	for _, user := range d.users {
+		if devSubject(user.Username) == userID {
+			return user
+		}
+	}
+	return nil
+}
+
+// tenant is the user's tenant, users without one belong to the default tenant.
+func (u *DevUser) tenant() string {
+	if u.Tenant == "" {
+		return "default"
+	}
+	return u.Tenant
+}
+
+func (d *Dev) issue(user *DevUser) (*TokenSet, error) {
+	now := time.Now()
+	tenant := user.tenant()
+	claims := jwt.MapClaims{
+		"iss":                d.cfg.Issuer,
+		"sub":                devSubject(user.Username),
+		"aud":                d.cfg.ClientID,
+		"azp":                d.cfg.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(d.cfg.TokenTTL).Unix(),
+		"jti":                randomToken(16),
+		"typ":                "Bearer",
+		"preferred_username": user.Username,
+		"email":              user.Email,
+		"email_verified":     !d.isUnverified(user.Username),
+		"given_name":         user.FirstName,
+		"family_name":        user.LastName,
+		"realm_access":       map[string]any{"roles": user.Roles},
+		"resource_access":    map[string]any{d.cfg.ClientID: map[string]any{"roles": user.Roles}},
+		d.cfg.TenantClaim:    tenant,
+	}
+	if len(user.Scopes) > 0 {
+		claims["scope"] = strings.Join(user.Scopes, " ")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = d.kid
+	signed, err := token.SignedString(d.key)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := randomToken(32)
+	d.mu.Lock()
+	d.sessions[refresh] = devSession{username: user.Username, expires: now.Add(d.cfg.RefreshTTL)}
+	d.mu.Unlock()
+
+	return &TokenSet{
+		AccessToken:  signed,
+		RefreshToken: refresh,
+		ExpiresIn:    int(d.cfg.TokenTTL.Seconds()),
+		TokenType:    "Bearer",
+	}, nil
+}
+
+func (d *Dev) user(u *DevUser) *User {
+	return &User{
+		ID:            devSubject(u.Username),
+		Username:      u.Username,
+		Email:         u.Email,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		Enabled:       !d.disabled[u.Username],
+		EmailVerified: !d.unverified[u.Username],
+		Tenant:        u.tenant(),
+	}
+}
+
+func (d *Dev) isDisabled(username string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.disabled[username]
+}
+
+func (d *Dev) isUnverified(username string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.unverified[username]
+}
+
+// devSubject derives a stable sub from the username, so movies created in
+// one run still belong to the same user after a restart.
+func devSubject(username string) string {
+	sum := sha256.Sum256([]byte("dev:" + username))
+	return hex.EncodeToString(sum[:16])
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
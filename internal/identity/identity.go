@@ -0,0 +1,107 @@
+// Package identity puts the user and token operations of the API behind one
+// interface, so the handlers do not depend on a particular identity provider.
+package identity
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	// ErrNotSupported is returned by providers that cannot perform an
+	// operation, e.g. user management through a plain OIDC provider.
+	ErrNotSupported = errors.New("operation not supported by the identity provider")
+)
+
+// TokenSet is the result of a login or refresh.
+type TokenSet struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+type User struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Enabled       bool   `json:"enabled"`
+	EmailVerified bool   `json:"email_verified"`
+	Tenant        string `json:"tenant"`
+}
+
+// UserQuery selects a page of users. Search matches username, email and names,
+// a non-empty Tenant restricts the page to the users of that tenant.
+type UserQuery struct {
+	Search string
+	Tenant string
+	First  int
+	Max    int
+}
+
+// NewUser is an account to be created with an initial password.
+// NewUser is created enabled, without roles and with an unverified email.
+type NewUser struct {
+	Username  string
+	Email     string
+	Password  string
+	FirstName string
+	LastName  string
+	// Tenant ends up in the tenant claim of the user's tokens
+	Tenant string
+}
+
+// ProfileUpdate holds the profile fields a user may change, nil fields are kept.
+type ProfileUpdate struct {
+	FirstName *string
+	LastName  *string
+	Email     *string
+}
+
+type IdentityProvider interface {
+	// Login exchanges a username and password for tokens.
+	Login(ctx context.Context, username, password string) (*TokenSet, error)
+	Refresh(ctx context.Context, refreshToken string) (*TokenSet, error)
+	// Logout ends the session the refresh token belongs to.
+	Logout(ctx context.Context, refreshToken string) error
+
+	// FindUser looks a user up by username, it returns ErrUserNotFound if there is none.
+	FindUser(ctx context.Context, username string) (*User, error)
+	// FindUserByEmail looks a user up by email address, it returns ErrUserNotFound if there is none.
+	FindUserByEmail(ctx context.Context, email string) (*User, error)
+	// CreateUser returns the new user's ID, or ErrUserExists.
+	CreateUser(ctx context.Context, user NewUser) (string, error)
+	// SendPasswordReset emails the user a link to choose a new password.
+	SendPasswordReset(ctx context.Context, userID string) error
+
+	// GetUser looks a user up by ID, the sub claim of their tokens.
+	GetUser(ctx context.Context, userID string) (*User, error)
+	// UpdateProfile applies update and returns the user. A changed email
+	// address is marked as not verified.
+	UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error)
+	SendVerifyEmail(ctx context.Context, userID string) error
+	// CheckPassword verifies a password without opening a session, a wrong
+	// password gives ErrInvalidCredentials.
+	CheckPassword(ctx context.Context, username, password string) error
+	// SetPassword replaces the password, the caller checks the current one first.
+	SetPassword(ctx context.Context, userID, password string) error
+
+	// ListUsers returns a page of users and the number of users matching the query.
+	ListUsers(ctx context.Context, query UserQuery) ([]User, int, error)
+	SetEnabled(ctx context.Context, userID string, enabled bool) error
+	// UserRoles returns the user's roles of the API client.
+	UserRoles(ctx context.Context, userID string) ([]string, error)
+	AddRole(ctx context.Context, userID, role string) error
+	RemoveRole(ctx context.Context, userID, role string) error
+	// LogoutAll ends every session of the user.
+	LogoutAll(ctx context.Context, userID string) error
+	// RequireActions makes the user perform actions such as UPDATE_PASSWORD
+	// on their next login and emails them a link to do so.
+	RequireActions(ctx context.Context, userID string, actions []string) error
+}
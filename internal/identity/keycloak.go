@@ -0,0 +1,395 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+type KeycloakConfig struct {
+	URL          string
+	Realm        string
+	ClientID     string
+	ClientSecret string
+	// Admin credentials for user management. Without them the client's
+	// service account is used, it needs the realm-management roles.
+	AdminUsername string
+	AdminPassword string
+	// RoleClientID is the client whose roles the API checks, the ID the
+	// admin API needs for it is looked up once.
+	RoleClientID string
+	// TenantAttribute is the user attribute mapped to the tenant claim.
+	TenantAttribute string
+}
+
+// Keycloak is the IdentityProvider backed by a Keycloak realm.
+type Keycloak struct {
+	client *gocloak.GoCloak
+	cfg    KeycloakConfig
+
+	mu         sync.Mutex
+	roleClient string
+}
+
+func NewKeycloak(cfg KeycloakConfig) *Keycloak {
+	return &Keycloak{client: gocloak.NewClient(cfg.URL), cfg: cfg}
+}
+
+func (k *Keycloak) Login(ctx context.Context, username, password string) (*TokenSet, error) {
+	token, err := k.client.Login(ctx, k.cfg.ClientID, k.cfg.ClientSecret, k.cfg.Realm, username, password)
+	if err != nil {
+		return nil, keycloakError(err, ErrInvalidCredentials, http.StatusBadRequest, http.StatusUnauthorized)
+	}
+	return tokenSet(token), nil
+}
+
+func (k *Keycloak) Refresh(ctx context.Context, refreshToken string) (*TokenSet, error) {
+	token, err := k.client.RefreshToken(ctx, refreshToken, k.cfg.ClientID, k.cfg.ClientSecret, k.cfg.Realm)
+	if err != nil {
+		return nil, keycloakError(err, ErrInvalidToken, http.StatusBadRequest, http.StatusUnauthorized)
+	}
+	return tokenSet(token), nil
+}
+
+func (k *Keycloak) Logout(ctx context.Context, refreshToken string) error {
+	if err := k.client.Logout(ctx, k.cfg.ClientID, k.cfg.ClientSecret, k.cfg.Realm, refreshToken); err != nil {
+		return keycloakError(err, ErrInvalidToken, http.StatusBadRequest, http.StatusUnauthorized)
+	}
+	return nil
+}
+
+func (k *Keycloak) FindUser(ctx context.Context, username string) (*User, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := k.client.GetUsers(ctx, token, k.cfg.Realm, gocloak.GetUsersParams{
+		Username: gocloak.StringP(username),
+		Exact:    gocloak.BoolP(true),
+		Max:      gocloak.IntP(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	return k.fromKeycloakUser(users[0]), nil
+}
+
+func (k *Keycloak) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := k.client.GetUsers(ctx, token, k.cfg.Realm, gocloak.GetUsersParams{
+		Email: gocloak.StringP(email),
+		Exact: gocloak.BoolP(true),
+		Max:   gocloak.IntP(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	return k.fromKeycloakUser(users[0]), nil
+}
+
+func (k *Keycloak) CreateUser(ctx context.Context, user NewUser) (string, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	// A user attribute mapper copies the attribute into the tenant claim
+	var attributes *map[string][]string
+	if k.cfg.TenantAttribute != "" && user.Tenant != "" {
+		attributes = &map[string][]string{k.cfg.TenantAttribute: {user.Tenant}}
+	}
+	id, err := k.client.CreateUser(ctx, token, k.cfg.Realm, gocloak.User{
+		Attributes:    attributes,
+		Username:      gocloak.StringP(user.Username),
+		FirstName:     gocloak.StringP(user.FirstName),
+		LastName:      gocloak.StringP(user.LastName),
+		Email:         gocloak.StringP(user.Email),
+		Enabled:       gocloak.BoolP(true),
+		EmailVerified: gocloak.BoolP(false),
+		Credentials: &[]gocloak.CredentialRepresentation{
+			{
+				Type:      gocloak.StringP("password"),
+				Value:     gocloak.StringP(user.Password),
+				Temporary: gocloak.BoolP(false),
+			},
+		},
+	})
+	if err != nil {
+		return "", keycloakError(err, ErrUserExists, http.StatusConflict)
+	}
+	return id, nil
+}
+
+func (k *Keycloak) SendPasswordReset(ctx context.Context, userID string) error {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return err
+	}
+	return k.client.ExecuteActionsEmail(ctx, token, k.cfg.Realm, gocloak.ExecuteActionsEmail{
+		UserID:   gocloak.StringP(userID),
+		ClientID: gocloak.StringP(k.cfg.ClientID),
+		Actions:  &[]string{"UPDATE_PASSWORD"},
+	})
+}
+
+func (k *Keycloak) GetUser(ctx context.Context, userID string) (*User, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := k.client.GetUserByID(ctx, token, k.cfg.Realm, userID)
+	if err != nil {
+		return nil, keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+	}
+	return k.fromKeycloakUser(user), nil
+}
+
+func (k *Keycloak) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := k.client.GetUserByID(ctx, token, k.cfg.Realm, userID)
+	if err != nil {
+		return nil, keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+	}
+
+	if update.FirstName != nil {
+		user.FirstName = update.FirstName
+	}
+	if update.LastName != nil {
+		user.LastName = update.LastName
+	}
+	if update.Email != nil && !strings.EqualFold(*update.Email, gocloak.PString(user.Email)) {
+		user.Email = update.Email
+		user.EmailVerified = gocloak.BoolP(false)
+	}
+	if err := k.client.UpdateUser(ctx, token, k.cfg.Realm, *user); err != nil {
+		return nil, keycloakError(err, ErrUserExists, http.StatusConflict)
+	}
+	return k.fromKeycloakUser(user), nil
+}
+
+func (k *Keycloak) SendVerifyEmail(ctx context.Context, userID string) error {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return err
+	}
+	return k.client.SendVerifyEmail(ctx, token, userID, k.cfg.Realm, gocloak.SendVerificationMailParams{
+		ClientID: gocloak.StringP(k.cfg.ClientID),
+	})
+}
+
+func (k *Keycloak) CheckPassword(ctx context.Context, username, password string) error {
+	// A password login is the only way Keycloak offers to check a password
+	session, err := k.client.Login(ctx, k.cfg.ClientID, k.cfg.ClientSecret, k.cfg.Realm, username, password)
+	if err != nil {
+		return keycloakError(err, ErrInvalidCredentials, http.StatusBadRequest, http.StatusUnauthorized)
+	}
+	k.client.Logout(ctx, k.cfg.ClientID, k.cfg.ClientSecret, k.cfg.Realm, session.RefreshToken)
+	return nil
+}
+
+func (k *Keycloak) SetPassword(ctx context.Context, userID, password string) error {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = k.client.SetPassword(ctx, token, userID, k.cfg.Realm, password, false)
+	return keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+}
+
+func (k *Keycloak) ListUsers(ctx context.Context, query UserQuery) ([]User, int, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	params := gocloak.GetUsersParams{
+		First: gocloak.IntP(query.First),
+		Max:   gocloak.IntP(query.Max),
+	}
+	if query.Search != "" {
+		params.Search = gocloak.StringP(query.Search)
+	}
+	if query.Tenant != "" {
+		params.Q = gocloak.StringP(k.cfg.TenantAttribute + ":" + query.Tenant)
+	}
+	users, err := k.client.GetUsers(ctx, token, k.cfg.Realm, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	params.First, params.Max = nil, nil
+	total, err := k.client.GetUserCount(ctx, token, k.cfg.Realm, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := make([]User, len(users))
+	for i, u := range users {
+		out[i] = *k.fromKeycloakUser(u)
+	}
+	return out, total, nil
+}
+
+func (k *Keycloak) SetEnabled(ctx context.Context, userID string, enabled bool) error {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return err
+	}
+	// UpdateUser sends the whole representation, so the other fields are kept
+	user, err := k.client.GetUserByID(ctx, token, k.cfg.Realm, userID)
+	if err != nil {
+		return keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+	}
+	user.Enabled = gocloak.BoolP(enabled)
+	return k.client.UpdateUser(ctx, token, k.cfg.Realm, *user)
+}
+
+func (k *Keycloak) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	token, client, err := k.roleClientToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := k.client.GetClientRolesByUserID(ctx, token, k.cfg.Realm, client, userID)
+	if err != nil {
+		return nil, keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, gocloak.PString(role.Name))
+	}
+	return names, nil
+}
+
+func (k *Keycloak) AddRole(ctx context.Context, userID, role string) error {
+	return k.changeRole(ctx, userID, role, k.client.AddClientRolesToUser)
+}
+
+func (k *Keycloak) RemoveRole(ctx context.Context, userID, role string) error {
+	return k.changeRole(ctx, userID, role, k.client.DeleteClientRolesFromUser)
+}
+
+func (k *Keycloak) changeRole(ctx context.Context, userID, role string, change func(ctx context.Context, token, realm, idOfClient, userID string, roles []gocloak.Role) error) error {
+	token, client, err := k.roleClientToken(ctx)
+	if err != nil {
+		return err
+	}
+	representation, err := k.client.GetClientRole(ctx, token, k.cfg.Realm, client, role)
+	if err != nil {
+		return fmt.Errorf("client role %s: %w", role, err)
+	}
+	err = change(ctx, token, k.cfg.Realm, client, userID, []gocloak.Role{*representation})
+	return keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+}
+
+func (k *Keycloak) LogoutAll(ctx context.Context, userID string) error {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = k.client.LogoutAllSessions(ctx, token, k.cfg.Realm, userID)
+	return keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+}
+
+func (k *Keycloak) RequireActions(ctx context.Context, userID string, actions []string) error {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = k.client.ExecuteActionsEmail(ctx, token, k.cfg.Realm, gocloak.ExecuteActionsEmail{
+		UserID:   gocloak.StringP(userID),
+		ClientID: gocloak.StringP(k.cfg.ClientID),
+		Actions:  &actions,
+	})
+	return keycloakError(err, ErrUserNotFound, http.StatusNotFound)
+}
+
+// roleClientToken returns an admin token and the internal ID of the role client.
+func (k *Keycloak) roleClientToken(ctx context.Context) (string, string, error) {
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.roleClient != "" {
+		return token, k.roleClient, nil
+	}
+	clients, err := k.client.GetClients(ctx, token, k.cfg.Realm, gocloak.GetClientsParams{ClientID: gocloak.StringP(k.cfg.RoleClientID)})
+	if err != nil {
+		return "", "", err
+	}
+	if len(clients) == 0 {
+		return "", "", fmt.Errorf("client %s not found in realm %s", k.cfg.RoleClientID, k.cfg.Realm)
+	}
+	k.roleClient = gocloak.PString(clients[0].ID)
+	return token, k.roleClient, nil
+}
+
+// adminToken logs in for a call to the admin REST API.
+func (k *Keycloak) adminToken(ctx context.Context) (string, error) {
+	var token *gocloak.JWT
+	var err error
+	if k.cfg.AdminUsername != "" {
+		token, err = k.client.LoginAdmin(ctx, k.cfg.AdminUsername, k.cfg.AdminPassword, k.cfg.Realm)
+	} else {
+		token, err = k.client.LoginClient(ctx, k.cfg.ClientID, k.cfg.ClientSecret, k.cfg.Realm)
+	}
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// keycloakError turns the answers Keycloak gives with one of the status codes
+// into mapped, other errors are returned as they are.
+func keycloakError(err error, mapped error, codes ...int) error {
+	var apiErr *gocloak.APIError
+	if errors.As(err, &apiErr) && slices.Contains(codes, apiErr.Code) {
+		return mapped
+	}
+	return err
+}
+
+func tokenSet(token *gocloak.JWT) *TokenSet {
+	return &TokenSet{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresIn:    token.ExpiresIn,
+		TokenType:    token.TokenType,
+	}
+}
+
+func (k *Keycloak) fromKeycloakUser(u *gocloak.User) *User {
+	var tenant string
+	if u.Attributes != nil {
+		if values := (*u.Attributes)[k.cfg.TenantAttribute]; len(values) > 0 {
+			tenant = values[0]
+		}
+	}
+	return &User{
+		ID:            gocloak.PString(u.ID),
+		Username:      gocloak.PString(u.Username),
+		Email:         gocloak.PString(u.Email),
+		FirstName:     gocloak.PString(u.FirstName),
+		LastName:      gocloak.PString(u.LastName),
+		Enabled:       gocloak.PBool(u.Enabled),
+		EmailVerified: gocloak.PBool(u.EmailVerified),
+		Tenant:        tenant,
+	}
+}
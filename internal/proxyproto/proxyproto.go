@@ -0,0 +1,196 @@
+// Package proxyproto reads the PROXY protocol header (versions 1 and 2) that
+// load balancers such as HAProxy or AWS NLB send ahead of a proxied
+// connection, so the connection reports the client's address instead of the
+// proxy's.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoHeader      = errors.New("proxyproto: connection does not start with a PROXY protocol header")
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+)
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLength is the longest version 1 header, CRLF included.
+const v1MaxLength = 107
+
+// Listener expects the header on connections from peers Trusted accepts, a
+// nil Trusted accepts every peer. Other peers are served as they are, their
+// headers are not read. Timeout bounds the wait for the header.
+type Listener struct {
+	net.Listener
+	Trusted func(netip.Addr) bool
+	Timeout time.Duration
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted != nil && !l.Trusted(addrOf(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, timeout: l.Timeout}, nil
+}
+
+// Conn reads the header on first use rather than in Accept, so a slow proxy
+// only holds up its own connection.
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.remote, c.err = readHeader(c.r)
+		if c.remote == nil {
+			c.remote = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+// Read fails for good if the header was missing or invalid.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr is the client's address from the header. It stays the proxy's
+// address for health checks (LOCAL, UNKNOWN) and when the header was invalid.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readHeader returns the source address in the header, nil if the header
+// carries none.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(5)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoHeader, err)
+	}
+	switch {
+	case string(start) == "PROXY":
+		return readV1(r)
+	case bytes.HasPrefix(v2Signature, start):
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses the text format, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("%w: version 1 header is not terminated", ErrInvalidHeader)
+	}
+
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("%w: source address %q", ErrInvalidHeader, fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: source port %q", ErrInvalidHeader, fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readV2 parses the binary format: signature, version and command, address
+// family, length of the rest, then the addresses and optional TLVs.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if !bytes.Equal(header[:12], v2Signature) || header[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: bad version 2 signature", ErrInvalidHeader)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+
+	switch header[12] & 0x0f {
+	case 0x0: // LOCAL, e.g. a health check of the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: unknown command %#x", ErrInvalidHeader, header[12]&0x0f)
+	}
+
+	var ip netip.Addr
+	var port []byte
+	switch family := header[13] >> 4; {
+	case family == 0x1 && len(body) >= 12:
+		ip = netip.AddrFrom4([4]byte(body[:4]))
+		port = body[8:10]
+	case family == 0x2 && len(body) >= 36:
+		ip = netip.AddrFrom16([16]byte(body[:16]))
+		port = body[32:34]
+	case family == 0x1 || family == 0x2:
+		return nil, fmt.Errorf("%w: address block too short", ErrInvalidHeader)
+	default: // UNSPEC or a unix socket, nothing useful to report
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port))), nil
+}
+
+func addrOf(addr net.Addr) netip.Addr {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.AddrPort().Addr().Unmap()
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
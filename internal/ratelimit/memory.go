@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// memoryShards is the number of independently locked parts of a MemoryStore.
+const memoryShards = 64
+
+// MemoryStore keeps the limits of this instance in memory. The keys are spread
+// over shards with a lock of their own, so parallel requests rarely wait for
+// each other, and each shard holds at most its share of the key cap. A full
+// shard evicts its least recently used key.
+//
+// Like the shared stores it keeps only the TAT per key, in nanoseconds so that
+// a burst never comes out one short to rounding. A key whose TAT has passed is
+// the same as a key never seen: cleaning it up changes nothing, and evicting a
+// key costs at most the part of its burst it had used.
+type MemoryStore struct {
+	seed   maphash.Seed
+	shards [memoryShards]memoryShard
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	// head.next is the most, head.prev the least recently used entry
+	head memoryEntry
+	max  int
+}
+
+type memoryEntry struct {
+	key        string
+	tat        int64
+	prev, next *memoryEntry
+}
+
+// NewMemoryStore returns a store holding up to about maxKeys keys.
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	s := &MemoryStore{seed: maphash.MakeSeed()}
+	perShard := max((maxKeys+memoryShards-1)/memoryShards, 1)
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.entries = make(map[string]*memoryEntry)
+		shard.head.prev, shard.head.next = &shard.head, &shard.head
+		shard.max = perShard
+	}
+	return s
+}
+
+func (s *MemoryStore) shard(key string) *memoryShard {
+	return &s.shards[maphash.String(s.seed, key)%memoryShards]
+}
+
+func (s *MemoryStore) Allow(_ context.Context, key string, limit Limit, cost int) (Decision, error) {
+	if decision, ok := tooLarge(limit, cost); ok {
+		return decision, nil
+	}
+
+	interval := int64(limit.interval() * float64(time.Second))
+	tolerance := interval * int64(limit.Burst)
+	now := time.Now().UnixNano()
+
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.entries[key]
+	tat := now
+	if entry != nil {
+		tat = max(entry.tat, now)
+		shard.moveToFront(entry)
+	}
+
+	next := tat + int64(cost)*interval
+	if next-tolerance > now {
+		return Decision{
+			RetryAfter: time.Duration(next - tolerance - now),
+			ResetAfter: time.Duration(tat - now),
+		}, nil
+	}
+
+	if entry == nil {
+		entry = shard.add(key)
+	}
+	entry.tat = next
+	return Decision{
+		Allowed:    true,
+		Remaining:  int((tolerance - (next - now)) / interval),
+		ResetAfter: time.Duration(next - now),
+	}, nil
+}
+
+// add inserts a new most recently used entry, evicting the least recently
+// used one if the shard is full.
+func (sh *memoryShard) add(key string) *memoryEntry {
+	if len(sh.entries) >= sh.max {
+		sh.remove(sh.head.prev)
+	}
+	entry := &memoryEntry{key: key}
+	sh.entries[key] = entry
+	sh.link(entry)
+	return entry
+}
+
+func (sh *memoryShard) link(entry *memoryEntry) {
+	entry.prev, entry.next = &sh.head, sh.head.next
+	sh.head.next.prev = entry
+	sh.head.next = entry
+}
+
+func (sh *memoryShard) unlink(entry *memoryEntry) {
+	entry.prev.next = entry.next
+	entry.next.prev = entry.prev
+	entry.prev, entry.next = nil, nil
+}
+
+func (sh *memoryShard) moveToFront(entry *memoryEntry) {
+	if sh.head.next != entry {
+		sh.unlink(entry)
+		sh.link(entry)
+	}
+}
+
+func (sh *memoryShard) remove(entry *memoryEntry) {
+	sh.unlink(entry)
+	delete(sh.entries, entry.key)
+}
+
+// DeleteIdle removes the keys that are fully replenished. Shards are locked
+// one after the other, never all at once.
+func (s *MemoryStore) DeleteIdle() int {
+	removed := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		now := time.Now().UnixNano()
+		for entry := shard.head.prev; entry != &shard.head; {
+			prev := entry.prev
+			if entry.tat <= now {
+				shard.remove(entry)
+				removed++
+			}
+			entry = prev
+		}
+		shard.mu.Unlock()
+	}
+	return removed
+}
+
+// Len returns the number of keys held.
+func (s *MemoryStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		n += len(shard.entries)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+// Run calls DeleteIdle every interval until ctx is done, then returns. After
+// each cleanup swept, if not nil, is called with the number of keys left.
+func (s *MemoryStore) Run(ctx context.Context, interval time.Duration, swept func(keys int)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.DeleteIdle()
+			if swept != nil {
+				swept(s.Len())
+			}
+		}
+	}
+}
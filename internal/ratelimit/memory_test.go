@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mutexStore is the design MemoryStore replaced: one map behind one mutex,
+// without a cap. It runs the same GCRA so the benchmarks compare locking only.
+type mutexStore struct {
+	mu   sync.Mutex
+	tats map[string]int64
+}
+
+func newMutexStore() *mutexStore {
+	return &mutexStore{tats: make(map[string]int64)}
+}
+
+func (s *mutexStore) Allow(_ context.Context, key string, limit Limit, cost int) (Decision, error) {
+	interval := int64(limit.interval() * float64(time.Second))
+	tolerance := interval * int64(limit.Burst)
+	now := time.Now().UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tat := max(s.tats[key], now)
+	next := tat + int64(cost)*interval
+	if next-tolerance > now {
+		return Decision{RetryAfter: time.Duration(next - tolerance - now)}, nil
+	}
+	s.tats[key] = next
+	return Decision{Allowed: true, Remaining: int((tolerance - (next - now)) / interval)}, nil
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	store := NewMemoryStore(100)
+	limit := Limit{Rate: 3, Burst: 3}
+
+	for i := range 3 {
+		decision, err := store.Allow(context.Background(), "ip:192.0.2.1", limit, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decision.Allowed || decision.Remaining != 2-i {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i+1, decision, 2-i)
+		}
+	}
+
+	decision, _ := store.Allow(context.Background(), "ip:192.0.2.1", limit, 1)
+	if decision.Allowed {
+		t.Fatal("request past the burst was allowed")
+	}
+	if decision.RetryAfter <= 0 || decision.RetryAfter > time.Second/3 {
+		t.Errorf("RetryAfter = %v, want up to one interval", decision.RetryAfter)
+	}
+
+	other, _ := store.Allow(context.Background(), "ip:192.0.2.2", limit, 1)
+	if !other.Allowed {
+		t.Error("another key was limited by the first")
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	const maxKeys = 1024
+	store := NewMemoryStore(maxKeys)
+	limit := Limit{Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	// Keep one key busy while a flood of spoofed addresses arrives
+	store.Allow(ctx, "ip:198.51.100.7", limit, 1)
+	for i := range 100 * maxKeys {
+		store.Allow(ctx, "ip:10."+strconv.Itoa(i>>16&255)+"."+strconv.Itoa(i>>8&255)+"."+strconv.Itoa(i&255), limit, 1)
+		if i%256 == 0 {
+			store.Allow(ctx, "ip:198.51.100.7", limit, 0)
+		}
+	}
+
+	// Every shard is capped at its share, rounded up
+	if n := store.Len(); n > maxKeys {
+		t.Fatalf("store holds %d keys, want at most %d", n, maxKeys)
+	}
+	shard := store.shard("ip:198.51.100.7")
+	shard.mu.Lock()
+	_, kept := shard.entries["ip:198.51.100.7"]
+	shard.mu.Unlock()
+	if !kept {
+		t.Error("the recently used key was evicted")
+	}
+	if _, held := store.shard("ip:10.0.0.0").entries["ip:10.0.0.0"]; held {
+		t.Error("the oldest spoofed key is still held")
+	}
+}
+
+func TestMemoryStoreDeleteIdle(t *testing.T) {
+	store := NewMemoryStore(100)
+	ctx := context.Background()
+
+	store.Allow(ctx, "fast", Limit{Rate: 1000, Burst: 1}, 1)
+	store.Allow(ctx, "slow", Limit{Rate: 0.001, Burst: 1}, 1)
+	time.Sleep(5 * time.Millisecond)
+
+	if removed := store.DeleteIdle(); removed != 1 {
+		t.Fatalf("DeleteIdle removed %d keys, want 1", removed)
+	}
+	if decision, _ := store.Allow(ctx, "slow", Limit{Rate: 0.001, Burst: 1}, 1); decision.Allowed {
+		t.Error("the key still inside its burst was cleaned up")
+	}
+}
+
+func TestMemoryStoreRunStopsOnShutdown(t *testing.T) {
+	store := NewMemoryStore(100)
+	store.Allow(context.Background(), "idle", Limit{Rate: 1000, Burst: 1}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sweeps := make(chan int, 16)
+	done := make(chan struct{})
+	go func() {
+		store.Run(ctx, time.Millisecond, func(keys int) {
+			select {
+			case sweeps <- keys:
+			default:
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case keys := <-sweeps:
+		if keys != 0 {
+			t.Errorf("%d keys left after a cleanup, want 0", keys)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the cleanup never ran")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the cleanup goroutine did not stop after shutdown")
+	}
+}
+
+// benchmarkParallel spreads b.N requests over GOMAXPROCS goroutines. Each
+// goroutine walks its own slice of keyspace, keyspace 1 is a single hot key.
+func benchmarkParallel(b *testing.B, store Store, keyspace int) {
+	keys := make([]string, keyspace)
+	for i := range keys {
+		keys[i] = "ip:" + strconv.Itoa(i)
+	}
+	// Generous enough that every request is allowed and writes its key
+	limit := Limit{Rate: 1e9, Burst: 1 << 30}
+	var worker atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(worker.Add(1)) * 7919
+		for pb.Next() {
+			store.Allow(context.Background(), keys[i%keyspace], limit, 1)
+			i++
+		}
+	})
+}
+
+func BenchmarkAllowParallel(b *testing.B) {
+	workloads := []struct {
+		name     string
+		keyspace int
+	}{
+		{"hot-key", 1},
+		{"many-keys", 50000},
+	}
+	for _, w := range workloads {
+		b.Run(w.name+"/sharded", func(b *testing.B) {
+			benchmarkParallel(b, NewMemoryStore(100000), w.keyspace)
+		})
+		b.Run(w.name+"/single-mutex", func(b *testing.B) {
+			benchmarkParallel(b, newMutexStore(), w.keyspace)
+		})
+	}
+}
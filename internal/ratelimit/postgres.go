@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps the TAT of every key in the rate_limits table. A
+// request is decided by a single upsert, so concurrent instances cannot both
+// take the last unit of a burst. Time is the database's, instances with
+// skewed clocks still agree on it.
+type PostgresStore struct {
+	db      *gorm.DB
+	timeout time.Duration
+}
+
+func NewPostgresStore(db *gorm.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresStore) Allow(ctx context.Context, key string, limit Limit, cost int) (Decision, error) {
+	if decision, ok := tooLarge(limit, cost); ok {
+		return decision, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	args := map[string]any{
+		"key": key,
+		"inc": float64(cost) * limit.interval(),
+		"tau": limit.tolerance(),
+	}
+
+	// The update only happens when the request fits, otherwise no row is returned
+	var rows []pgTAT
+	err := s.db.WithContext(ctx).Raw(`
+	INSERT INTO rate_limits AS r (key, tat) VALUES (@key, `+pgNow+` + @inc)
+	ON CONFLICT (key) DO UPDATE SET tat = GREATEST(r.tat, `+pgNow+`) + @inc
+	WHERE GREATEST(r.tat, `+pgNow+`) + @inc - @tau <= `+pgNow+`
+	RETURNING tat, `+pgNow+` AS now`, args).Scan(&rows).Error
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(rows) == 1 {
+		return allowed(rows[0].TAT, rows[0].Now, limit), nil
+	}
+
+	var current pgTAT
+	if err := s.db.WithContext(ctx).Raw(`SELECT tat, `+pgNow+` AS now FROM rate_limits WHERE key = ?`, key).Scan(&current).Error; err != nil {
+		return Decision{}, err
+	}
+	return denied(current.TAT, current.Now, limit, cost), nil
+}
+
+// pgNow is the database time in Unix seconds, fixed for the statement.
+const pgNow = `extract(epoch FROM now())::float8`
+
+type pgTAT struct {
+	TAT float64
+	Now float64
+}
+
+// DeleteIdle removes keys that are fully replenished, they are the same as
+// keys never seen.
+func (s *PostgresStore) DeleteIdle(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result := s.db.WithContext(ctx).Exec(`DELETE FROM rate_limits WHERE tat < ` + pgNow)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,120 @@
+// Package ratelimit decides whether a request fits in its rate limit. The
+// stores share the limits between API instances, except for the local
+// fallback used while a shared store cannot be reached.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Rate requests per second on average and bursts of up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Decision is the outcome of one Allow call.
+type Decision struct {
+	Allowed bool
+	// Remaining is how much more cost fits in the burst right now
+	Remaining int
+	// RetryAfter is how long a denied request has to wait to fit
+	RetryAfter time.Duration
+	// ResetAfter is how long until the full burst is available again
+	ResetAfter time.Duration
+}
+
+// Store takes cost from the limit of key. A denied request takes nothing.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit, cost int) (Decision, error)
+}
+
+// The shared stores implement GCRA, the generic cell rate algorithm. Instead
+// of a token count they keep a single number per key, the theoretical arrival
+// time (TAT) at which the key would be fully replenished. A request of cost n
+// moves the TAT n emission intervals ahead and fits as long as the TAT stays
+// within burst intervals of now. All times are in seconds.
+
+// interval is the time one unit of cost takes to replenish.
+func (l Limit) interval() float64 {
+	return 1 / l.Rate
+}
+
+// tolerance is how far ahead of now the TAT may be.
+func (l Limit) tolerance() float64 {
+	return l.interval() * float64(l.Burst)
+}
+
+// allowed describes an accepted request that moved the TAT to tat.
+func allowed(tat, now float64, limit Limit) Decision {
+	ahead := math.Max(tat-now, 0)
+	return Decision{
+		Allowed:    true,
+		Remaining:  int(math.Floor((limit.tolerance() - ahead) / limit.interval())),
+		ResetAfter: seconds(ahead),
+	}
+}
+
+// denied describes a rejected request of cost against the current tat.
+func denied(tat, now float64, limit Limit, cost int) Decision {
+	tat = math.Max(tat, now)
+	return Decision{
+		RetryAfter: seconds(tat + float64(cost)*limit.interval() - limit.tolerance() - now),
+		ResetAfter: seconds(tat - now),
+	}
+}
+
+// tooLarge rejects a cost that would not fit even in a full burst.
+func tooLarge(limit Limit, cost int) (Decision, bool) {
+	if cost <= limit.Burst {
+		return Decision{}, false
+	}
+	return Decision{RetryAfter: seconds(limit.tolerance())}, true
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(s, 0) * float64(time.Second))
+}
+
+// Fallback asks Primary and falls back to Local when Primary fails. After a
+// failure Primary is left alone for Cooldown, so an unreachable store does not
+// add its timeout to every request. While falling back each instance enforces
+// the limits on its own.
+type Fallback struct {
+	primary  Store
+	local    Store
+	cooldown time.Duration
+	onError  func(error)
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NewFallback returns a store preferring primary. onError, if not nil, is
+// called with every error of primary.
+func NewFallback(primary, local Store, cooldown time.Duration, onError func(error)) *Fallback {
+	return &Fallback{primary: primary, local: local, cooldown: cooldown, onError: onError}
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, limit Limit, cost int) (Decision, error) {
+	f.mu.Lock()
+	down := time.Now().Before(f.downUntil)
+	f.mu.Unlock()
+
+	if !down {
+		decision, err := f.primary.Allow(ctx, key, limit, cost)
+		if err == nil {
+			return decision, nil
+		}
+		f.mu.Lock()
+		f.downUntil = time.Now().Add(f.cooldown)
+		f.mu.Unlock()
+		if f.onError != nil {
+			f.onError(err)
+		}
+	}
+	return f.local.Allow(ctx, key, limit, cost)
+}
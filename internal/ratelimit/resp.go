@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// respClient speaks just enough of the Redis serialization protocol (RESP2)
+// to run scripts. It works with any server that implements the protocol,
+// Redis, Valkey, KeyDB or Dragonfly.
+type respClient struct {
+	addr     string
+	username string
+	password string
+	db       int
+	useTLS   bool
+	timeout  time.Duration
+	pool     chan *respConn
+}
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// respError is an error reply of the server. The connection stays usable.
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+// newRESPClient parses a redis:// or rediss:// URL such as
+// redis://:password@localhost:6379/0.
+func newRESPClient(rawURL string, timeout time.Duration, poolSize int) (*respClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("redis url must start with redis:// or rediss://, got %q", u.Scheme)
+	}
+
+	c := &respClient{
+		addr:    u.Host,
+		useTLS:  u.Scheme == "rediss",
+		timeout: timeout,
+		pool:    make(chan *respConn, poolSize),
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if c.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("redis url: invalid database %q", path)
+		}
+	}
+	return c, nil
+}
+
+// do sends one command and reads its reply. Error replies are returned as respError.
+func (c *respClient) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.roundTrip(ctx, c.timeout, args)
+	var replyErr respError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The stream may be out of sync, the connection cannot be reused
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+func (c *respClient) get(ctx context.Context) (*respConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.useTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: strings.Split(c.addr, ":")[0]}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &respConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		auth := []string{"AUTH", c.password}
+		if c.username != "" {
+			auth = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := rc.roundTrip(ctx, c.timeout, auth); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.roundTrip(ctx, c.timeout, []string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis select: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+func (c *respClient) put(conn *respConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+func (rc *respConn) roundTrip(ctx context.Context, timeout time.Duration, args []string) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := rc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Commands are sent as an array of bulk strings
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+// readReply returns a string, int64, nil, []any or respError.
+func (rc *respConn) readReply() (any, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				var replyErr respError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
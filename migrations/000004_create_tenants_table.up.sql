@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS tenants (
+	id text PRIMARY KEY,
+	name text NOT NULL,
+	created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+	rate_limit_rps double precision CHECK (rate_limit_rps > 0),
+	rate_limit_burst integer CHECK (rate_limit_burst >= 1),
+	allowed_genres text[] NOT NULL DEFAULT '{}',
+	version integer NOT NULL DEFAULT 1
+);
+
+INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON CONFLICT (id) DO NOTHING;
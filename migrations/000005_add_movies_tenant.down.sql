@@ -0,0 +1,5 @@
+DROP POLICY IF EXISTS movies_tenant_isolation ON movies;
+ALTER TABLE movies NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE movies DISABLE ROW LEVEL SECURITY;
+DROP INDEX IF EXISTS movies_tenant_id_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS tenant_id;
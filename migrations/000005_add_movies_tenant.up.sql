@@ -0,0 +1,13 @@
+-- Existing movies belong to the default tenant
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tenant_id text NOT NULL DEFAULT 'default' REFERENCES tenants (id);
+ALTER TABLE movies ALTER COLUMN tenant_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS movies_tenant_id_idx ON movies (tenant_id);
+
+-- The API sets app.tenant_id for each transaction, rows of other tenants are
+-- invisible and cannot be written. FORCE applies the policy to the table owner too.
+ALTER TABLE movies ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movies FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS movies_tenant_isolation ON movies;
+CREATE POLICY movies_tenant_isolation ON movies
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
@@ -0,0 +1,4 @@
+-- Movies created before ownership was tracked have no owner and can only be changed by admins
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_by text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS movies_tenant_id_created_by_idx ON movies (tenant_id, created_by);
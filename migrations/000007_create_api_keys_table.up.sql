@@ -0,0 +1,18 @@
+-- Only the SHA-256 of a key is stored, the prefix identifies it in listings and logs
+CREATE TABLE IF NOT EXISTS api_keys (
+	id bigserial PRIMARY KEY,
+	prefix text NOT NULL UNIQUE,
+	key_hash bytea NOT NULL,
+	name text NOT NULL,
+	tenant_id text NOT NULL REFERENCES tenants (id),
+	roles text[] NOT NULL DEFAULT '{}',
+	scopes text[] NOT NULL DEFAULT '{}',
+	created_by text NOT NULL DEFAULT '',
+	created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+	expires_at timestamp(0) with time zone,
+	last_used_at timestamp(0) with time zone,
+	revoked_at timestamp(0) with time zone,
+	replaced_by bigint REFERENCES api_keys (id)
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_tenant_id_idx ON api_keys (tenant_id);
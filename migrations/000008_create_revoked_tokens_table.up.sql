@@ -0,0 +1,9 @@
+-- Access tokens revoked before their expiry, rows are removed once the token would have expired anyway
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti text PRIMARY KEY,
+	subject text NOT NULL DEFAULT '',
+	expires_at timestamp(0) with time zone NOT NULL,
+	revoked_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
@@ -0,0 +1,14 @@
+-- Invitation codes are stored like API keys, only their SHA-256 is kept
+CREATE TABLE IF NOT EXISTS invitations (
+	id bigserial PRIMARY KEY,
+	hint text NOT NULL,
+	code_hash bytea NOT NULL UNIQUE,
+	roles text[] NOT NULL DEFAULT '{}',
+	max_uses integer NOT NULL DEFAULT 1,
+	uses integer NOT NULL DEFAULT 0,
+	created_by text NOT NULL DEFAULT '',
+	created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+	expires_at timestamp(0) with time zone NOT NULL,
+	revoked_at timestamp(0) with time zone,
+	CONSTRAINT invitations_uses_check CHECK (uses >= 0 AND uses <= max_uses)
+);
@@ -0,0 +1,20 @@
+-- Requests of users for an additional role, approved grants may expire
+CREATE TABLE IF NOT EXISTS role_requests (
+	id bigserial PRIMARY KEY,
+	user_id text NOT NULL,
+	username text NOT NULL DEFAULT '',
+	role text NOT NULL,
+	justification text NOT NULL,
+	requested_duration text NOT NULL DEFAULT '',
+	status text NOT NULL DEFAULT 'pending',
+	decided_by text NOT NULL DEFAULT '',
+	decided_at timestamp(0) with time zone,
+	note text NOT NULL DEFAULT '',
+	expires_at timestamp(0) with time zone,
+	created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+	CONSTRAINT role_requests_status_check CHECK (status IN ('pending', 'approved', 'denied', 'expired'))
+);
+
+-- A user can only have one open request per role
+CREATE UNIQUE INDEX IF NOT EXISTS role_requests_pending_idx ON role_requests (user_id, role) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS role_requests_expires_at_idx ON role_requests (expires_at) WHERE status = 'approved';
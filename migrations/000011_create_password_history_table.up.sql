@@ -0,0 +1,11 @@
+-- PBKDF2 hashes of the passwords a user had, to stop them from being reused
+CREATE TABLE IF NOT EXISTS password_history (
+	id bigserial PRIMARY KEY,
+	user_id text NOT NULL,
+	salt bytea NOT NULL,
+	iterations integer NOT NULL,
+	hash bytea NOT NULL,
+	created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS password_history_user_id_idx ON password_history (user_id, id DESC);
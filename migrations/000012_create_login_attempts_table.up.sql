@@ -0,0 +1,9 @@
+-- Failed logins per username and per client IP, keyed as user:<name> and ip:<addr>
+CREATE TABLE IF NOT EXISTS login_attempts (
+	key text PRIMARY KEY,
+	failures integer NOT NULL DEFAULT 0,
+	last_failure timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+	locked_until timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_idx ON login_attempts (last_failure);
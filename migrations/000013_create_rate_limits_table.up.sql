@@ -0,0 +1,7 @@
+-- Shared rate limits, tat is the Unix time in seconds at which the key's burst is full again
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+	key text PRIMARY KEY,
+	tat double precision NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_tat_idx ON rate_limits (tat);
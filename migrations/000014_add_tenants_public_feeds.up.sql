@@ -0,0 +1,5 @@
+-- Tenants opt in to feeds readable without a token, the default tenant keeps
+-- the public feeds it had before
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS public_feeds boolean NOT NULL DEFAULT false;
+
+UPDATE tenants SET public_feeds = true WHERE id = 'default';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS role_requests_tenant_id_idx;
+ALTER TABLE role_requests DROP COLUMN IF EXISTS tenant_id;
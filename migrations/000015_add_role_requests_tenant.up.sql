@@ -0,0 +1,5 @@
+-- Role requests belong to the tenant of the requesting user, so tenant admins
+-- only decide the requests of their own users
+ALTER TABLE role_requests ADD COLUMN IF NOT EXISTS tenant_id text NOT NULL DEFAULT 'default';
+
+CREATE INDEX IF NOT EXISTS role_requests_tenant_id_idx ON role_requests (tenant_id, status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS invitations_tenant_id_idx;
+ALTER TABLE invitations DROP COLUMN IF EXISTS tenant_id;
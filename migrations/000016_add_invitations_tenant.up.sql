@@ -0,0 +1,5 @@
+-- Invitations register users into a tenant, tenant admins only see and revoke
+-- the invitations of their own tenant
+ALTER TABLE invitations ADD COLUMN IF NOT EXISTS tenant_id text NOT NULL DEFAULT 'default';
+
+CREATE INDEX IF NOT EXISTS invitations_tenant_id_idx ON invitations (tenant_id);
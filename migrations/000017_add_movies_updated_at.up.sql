@@ -0,0 +1,4 @@
+-- When a movie last changed, the feeds report it as Last-Modified
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+UPDATE movies SET updated_at = created_at;